@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"log"
 	"net/http"
 	"orders/internal/app"
+	"orders/internal/trace"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/swaggo/http-swagger"
@@ -23,31 +30,76 @@ func main() {
 		log.Fatalln("DRIVER is not found")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(driver, dbURL, os.Args[2:])
+		return
+	}
+
 	myApp, err := app.NewApp(driver, dbURL)
 	if err != nil {
 		log.Fatalln("Can't create db connection:", err)
 	}
 	defer myApp.Close()
 
+	// Свой mux вместо http.DefaultServeMux: expvar регистрирует на нем
+	// /debug/vars без авторизации
+	mux := http.NewServeMux()
+
 	// Отдаем статику
 	staticFileServer := http.FileServer(http.Dir("web/static"))
-	http.Handle("/static/", http.StripPrefix("/static/", staticFileServer))
+	mux.Handle("/static/", http.StripPrefix("/static/", staticFileServer))
 
 	// Основные эндпоинты
-	http.HandleFunc("/", myApp.HomeHandler)
-	http.HandleFunc("/orders", myApp.ShowOrdersHandler)
-	http.HandleFunc("/orders/{order_uid}", myApp.GetOrderByIdHandler)
-	http.HandleFunc("/random/{amount}", myApp.RandomOrdersHandler)
+	// Остальные пути получает mux, и app.Problems отвечает на них 404
+	mux.HandleFunc("/{$}", myApp.HomeHandler)
+	mux.HandleFunc("/orders", myApp.ShowOrdersHandler)
+	mux.HandleFunc("/orders/{order_uid}", myApp.GetOrderByIdHandler)
+	mux.HandleFunc("/random/{amount}", myApp.RandomOrdersHandler)
+
+	// Готовность сервиса и прогресс прогрева кэша
+	mux.HandleFunc("GET /ready", myApp.ReadyHandler)
+
+	// Управление консьюмером, требует ADMIN_TOKEN
+	mux.HandleFunc("GET /admin/consumer", myApp.AdminOnly(myApp.ConsumerStatusHandler))
+	mux.HandleFunc("POST /admin/consumer/pause", myApp.AdminOnly(myApp.ConsumerPauseHandler))
+	mux.HandleFunc("POST /admin/consumer/resume", myApp.AdminOnly(myApp.ConsumerResumeHandler))
+	mux.HandleFunc("POST /admin/consumer/seek", myApp.AdminOnly(myApp.ConsumerSeekHandler))
+
+	// Управление кэшем, требует ADMIN_TOKEN
+	mux.HandleFunc("GET /admin/cache", myApp.AdminOnly(myApp.CacheStatusHandler))
+	mux.HandleFunc("DELETE /admin/cache", myApp.AdminOnly(myApp.CacheFlushHandler))
+	mux.HandleFunc("PUT /admin/cache/capacity", myApp.AdminOnly(myApp.CacheResizeHandler))
+	mux.HandleFunc("GET /admin/cache/{order_uid}", myApp.AdminOnly(myApp.CacheInspectHandler))
+	mux.HandleFunc("DELETE /admin/cache/{order_uid}", myApp.AdminOnly(myApp.CacheEvictHandler))
+
+	// Метрики expvar, в том числе состояние breaker'ов, требуют ADMIN_TOKEN
+	mux.HandleFunc("GET /debug/vars", myApp.AdminOnly(expvar.Handler().ServeHTTP))
 
 	// Отдаем файл с документацией и рендерим его по эндпоинту /docs
-	http.HandleFunc("/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./docs/swagger.yaml")
 	})
-	http.Handle("/docs/", httpSwagger.Handler(httpSwagger.URL("/swagger.yaml")))
+	mux.Handle("/docs/", httpSwagger.Handler(httpSwagger.URL("/swagger.yaml")))
+
+	server := &http.Server{Addr: ":8080", Handler: trace.Middleware(app.Problems(mux))}
+
+	// По SIGTERM сервер перестает принимать запросы, после чего
+	// myApp.Close останавливает прогрев кэша и сохраняет его состояние
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Error shutting down the server:", err)
+		}
+	}()
 
 	log.Println("Server is running on http://localhost:8080")
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalln("Can't start the server:", err)
 	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"orders/internal/app"
+	k "orders/internal/kafka"
+)
+
+func runReplay(driver, dbURL string, args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: orders-service replay [flags]")
+		fmt.Fprintln(fs.Output(), "Re-reads the orders topic and saves messages through the regular ingestion pipeline.")
+		fs.PrintDefaults()
+	}
+
+	partition := fs.Int("partition", -1, "partition to replay, -1 for all partitions")
+	fromOffset := fs.Int64("from-offset", -1, "first offset to replay")
+	toOffset := fs.Int64("to-offset", -1, "last offset to replay (inclusive)")
+	fromTime := fs.String("from-time", "", "replay messages produced at or after this RFC3339 time")
+	toTime := fs.String("to-time", "", "replay messages produced before this RFC3339 time")
+	group := fs.String("group", k.DefaultReplayGroup, "consumer group used to record replay progress")
+	dryRun := fs.Bool("dry-run", false, "decode and validate messages without saving them")
+	fs.Parse(args)
+
+	opts := k.ReplayOptions{
+		Partition:  *partition,
+		FromOffset: *fromOffset,
+		ToOffset:   *toOffset,
+		GroupID:    *group,
+		DryRun:     *dryRun,
+	}
+
+	var err error
+	if *fromTime != "" {
+		if opts.FromTime, err = time.Parse(time.RFC3339, *fromTime); err != nil {
+			log.Fatalln("Invalid -from-time:", err)
+		}
+	}
+	if *toTime != "" {
+		if opts.ToTime, err = time.Parse(time.RFC3339, *toTime); err != nil {
+			log.Fatalln("Invalid -to-time:", err)
+		}
+	}
+
+	summary, err := app.Replay(driver, dbURL, opts)
+
+	mode := "Replay"
+	if opts.DryRun {
+		mode = "Replay (dry run)"
+	}
+	fmt.Printf("%s summary: %s\n", mode, summary)
+
+	if err != nil {
+		log.Println("Replay stopped with error:", err)
+		os.Exit(1)
+	}
+	if summary.Failed > 0 {
+		os.Exit(2)
+	}
+}
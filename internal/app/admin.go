@@ -0,0 +1,223 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	c "orders/internal/cache"
+	"orders/internal/config"
+	g "orders/internal/generator"
+)
+
+// Пропускает запрос только с заголовком Authorization: Bearer <ADMIN_TOKEN>.
+// Без ADMIN_TOKEN админские эндпоинты выключены
+func (a *App) AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	token := config.GetString("ADMIN_TOKEN", "")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeProblem(w, r, http.StatusForbidden, codeAdminDisabled, "Admin API is disabled, set ADMIN_TOKEN to enable it")
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "Valid admin bearer token is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *App) ConsumerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, a.consumer.Status(r.Context()))
+}
+
+func (a *App) ConsumerPauseHandler(w http.ResponseWriter, r *http.Request) {
+	a.consumer.Pause()
+	writeJSON(w, r, a.consumer.Status(r.Context()))
+}
+
+func (a *App) ConsumerResumeHandler(w http.ResponseWriter, r *http.Request) {
+	a.consumer.Resume()
+	writeJSON(w, r, a.consumer.Status(r.Context()))
+}
+
+type seekRequest struct {
+	Topic     string     `json:"topic"`
+	Partition *int       `json:"partition"`
+	Offset    *int64     `json:"offset"`
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// Перематывает топик на оффсет партиции или на момент времени:
+// {"topic": "orders", "partition": 0, "offset": 42}
+// {"topic": "orders", "timestamp": "2025-01-02T15:04:05Z"}
+func (a *App) ConsumerSeekHandler(w http.ResponseWriter, r *http.Request) {
+	var req seekRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	var err error
+	switch {
+	case req.Topic == "":
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "topic is required")
+		return
+	case req.Offset != nil && req.Timestamp != nil:
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "offset and timestamp are mutually exclusive")
+		return
+	case req.Offset != nil:
+		if req.Partition == nil || *req.Partition < 0 || *req.Offset < 0 {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "non-negative partition and offset are required")
+			return
+		}
+		err = a.consumer.Seek(r.Context(), req.Topic, *req.Partition, *req.Offset)
+	case req.Timestamp != nil:
+		err = a.consumer.SeekTime(r.Context(), req.Topic, *req.Timestamp)
+	default:
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "offset or timestamp is required")
+		return
+	}
+
+	if err != nil {
+		log.Println("Error seeking consumer:", err)
+		writeProblem(w, r, http.StatusConflict, codeSeekFailed, err.Error())
+		return
+	}
+	writeJSON(w, r, a.consumer.Status(r.Context()))
+}
+
+const defaultCacheEntries = 5
+
+type cacheStatus struct {
+	c.Stats
+	// Заказы, которые вытеснятся первыми и последними
+	Oldest []c.Entry `json:"oldest"`
+	Newest []c.Entry `json:"newest"`
+}
+
+// Состояние кэша. Число крайних записей задается параметром ?entries=N
+func (a *App) CacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	n := defaultCacheEntries
+	if value := r.URL.Query().Get("entries"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 0 {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "entries must be a non-negative integer")
+			return
+		}
+	}
+
+	stats, err := a.repo.Cache.Stats(ctx)
+	if err != nil {
+		writeServerError(w, r, "Error getting cache stats", err)
+		return
+	}
+	oldest, err := a.repo.Cache.Entries(ctx, n, true)
+	if err != nil {
+		writeServerError(w, r, "Error listing cache entries", err)
+		return
+	}
+	newest, err := a.repo.Cache.Entries(ctx, n, false)
+	if err != nil {
+		writeServerError(w, r, "Error listing cache entries", err)
+		return
+	}
+
+	writeJSON(w, r, cacheStatus{Stats: stats, Oldest: oldest, Newest: newest})
+}
+
+type cacheEntry struct {
+	c.Entry
+	Order *g.Order `json:"order"`
+}
+
+// Закэшированный заказ и его место в очереди вытеснения. Просмотр не
+// считается обращением к заказу
+func (a *App) CacheInspectHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("order_uid")
+	order, entry, err := a.repo.Cache.Inspect(r.Context(), uid)
+	if errors.Is(err, c.ErrMiss) {
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotCached, fmt.Sprintf("Order %s is not cached", uid))
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, "Error inspecting cached order", err)
+		return
+	}
+	writeJSON(w, r, cacheEntry{Entry: entry, Order: order})
+}
+
+func (a *App) CacheEvictHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("order_uid")
+	if err := a.repo.Cache.Remove(r.Context(), uid); err != nil {
+		writeServerError(w, r, "Error evicting cached order", err)
+		return
+	}
+	log.Println("Order evicted from cache by admin:", uid)
+	writeJSON(w, r, map[string]string{"evicted": uid})
+}
+
+func (a *App) CacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	flushed, err := a.repo.Cache.Flush(r.Context())
+	if err != nil {
+		writeServerError(w, r, "Error flushing cache", err)
+		return
+	}
+	log.Printf("Cache flushed by admin, %d orders removed\n", flushed)
+	writeJSON(w, r, map[string]int{"flushed": flushed})
+}
+
+type resizeRequest struct {
+	Capacity int `json:"capacity"`
+}
+
+// Меняет емкость кэша: {"capacity": 500}. Лишние заказы вытесняются сразу
+func (a *App) CacheResizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req resizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Capacity <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, "capacity must be positive")
+		return
+	}
+
+	evicted, err := a.repo.Cache.Resize(r.Context(), req.Capacity)
+	if err != nil {
+		writeServerError(w, r, "Error resizing cache", err)
+		return
+	}
+	log.Printf("Cache capacity changed by admin to %d, %d orders evicted\n", req.Capacity, evicted)
+	writeJSON(w, r, map[string]int{"capacity": req.Capacity, "evicted": evicted})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		writeServerError(w, r, "Error marshalling JSON", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
@@ -4,189 +4,337 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"orders/internal/generator"
+	"orders/internal/trace"
 	"os"
 	"strconv"
 
+	"orders/internal/breaker"
+	"orders/internal/bus"
 	c "orders/internal/cache"
+	"orders/internal/config"
+	"orders/internal/consumer"
 	k "orders/internal/kafka"
+	"orders/internal/messages"
+	"orders/internal/registry"
 	repo "orders/internal/repository"
+	"orders/internal/warmup"
 
 	_ "github.com/lib/pq"
-	"github.com/segmentio/kafka-go"
 )
 
 type App struct {
-	kafkaConsumer *kafka.Reader
-	kafkaProducer *kafka.Writer
-	repo          *repo.Repository
+	bus         bus.Bus
+	subscribers []bus.Subscriber
+	publisher   bus.Publisher
+	// nil, если KAFKA_DLQ_TOPIC пустой
+	deadLetters bus.Publisher
+	repo        *repo.Repository
+	consumer    *consumer.Controller
+	warmer      *warmup.Warmer
+	// nil, если CACHE_INVALIDATION=none
+	changes *repo.ChangeListener
 }
 
 func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		notFound, err := os.ReadFile("web/templates/404.html")
-		if err != nil {
-			log.Println("Error reading file:", err)
-			http.Error(w, "Nothing's here...", http.StatusNotFound)
-			return
-		}
-		if _, err := w.Write([]byte(notFound)); err != nil {
-			log.Fatalln("Handler error: HomeHandler:", err)
-		}
-		return
-	}
-
 	html, err := os.ReadFile("web/templates/index.html")
 	if err != nil {
-		log.Println("Error reading file:", err)
-		http.Error(w, "Nothing's here...", http.StatusNotFound)
+		writeServerError(w, r, "Error reading file", err)
 		return
 	}
 
 	if _, err := w.Write([]byte(html)); err != nil {
-		log.Fatalln("Handler error: HomeHandler:", err)
+		log.Println("Handler error: HomeHandler:", err)
 	}
 }
 
 func (a *App) GetOrderByIdHandler(w http.ResponseWriter, r *http.Request) {
 	order_uid := r.PathValue("order_uid")
-	ctx := context.Background()
+	ctx := r.Context()
 
-	orderData, err := a.repo.GetOrderById(order_uid, ctx, true)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Order not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+	rendered, err := a.repo.GetOrderResponse(order_uid, ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotFound, fmt.Sprintf("Order %s not found", order_uid))
+		return
 	}
-
-	orderJSON, err := json.MarshalIndent(orderData, "", "    ")
 	if err != nil {
-		log.Println("Error marshalling JSON:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeServerError(w, r, "Error getting order", err)
 		return
 	}
 
-	if _, err := w.Write([]byte(orderJSON)); err != nil {
-		log.Fatalln("Handler error: GetOrderByIdHandler:", err)
-	}
+	writeRendered(w, r, rendered)
 }
 
 func (a *App) ShowOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	ordersList, err := a.repo.GetAllOrders(ctx)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeServerError(w, r, "Error getting orders", err)
+		return
 	}
 
 	ordersJSON, err := json.MarshalIndent(ordersList, "", "    ")
 	if err != nil {
-		log.Println("Error marshalling JSON:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeServerError(w, r, "Error marshalling JSON", err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write([]byte(ordersJSON)); err != nil {
-		log.Fatalln("Handler error: ShowOrdersHandler:", err)
+		log.Println("Handler error: ShowOrdersHandler:", err)
 	}
 }
 
 func (a *App) RandomOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	value := r.PathValue("amount")
 	amount, err := strconv.Atoi(value)
-
-	badRequest := func() {
-		result, err := os.ReadFile("web/templates/400.html")
-		if err != nil {
-			http.Error(w, "Nothing's here...", http.StatusNotFound)
-			log.Fatalln("Error reading file:", err)
-		}
-		if _, err := w.Write([]byte(result)); err != nil {
-			log.Fatalln("Handler error: RandomOrdersHandler:", err)
-		}
+	if err != nil || amount <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidAmount, fmt.Sprintf("amount must be a positive integer, got %q", value))
+		return
 	}
 
+	ctx := r.Context()
+	orders := generator.MakeRandomOrder(amount)
+
+	orderJSON, err := json.MarshalIndent(orders, "", "    ")
 	if err != nil {
-		log.Println("Error in internal/app/app.go: amount, err := strconv.Atoi(value):", err)
-		badRequest()
-	} else {
-		if amount <= 0 {
-			log.Println("Error creating orders: Value is equal or less than zero")
-			badRequest()
-			return
-		}
+		writeServerError(w, r, "Error marshalling JSON", err)
+		return
+	}
 
-		ctx := context.Background()
-		orders := generator.MakeRandomOrder(amount)
+	msg, err := messages.EncodeOrders(ctx, orders)
+	if err != nil {
+		writeServerError(w, r, "Error encoding orders message", err)
+		return
+	}
 
-		orderJSON, err := json.MarshalIndent(orders, "", "    ")
-		if err != nil {
-			log.Println("Error marshalling JSON:", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	err = a.publisher.Publish(ctx, msg)
+	if err != nil {
+		log.Printf("[trace %s] Error publishing random orders: %v\n", trace.FromContext(ctx), err)
+		writeProblem(w, r, http.StatusServiceUnavailable, codePublishFailed, "Orders were not sent to message bus, retry later")
+		return
+	}
+	log.Printf("[trace %s] Sent %d random orders to message bus\n", trace.FromContext(ctx), amount)
 
-		err = k.WriteMessage(a.kafkaProducer, ctx, orderJSON)
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write([]byte(orderJSON)); err != nil {
+		log.Println("Handler error: RandomOrdersHandler:", err)
+	}
+}
 
-		if _, err := w.Write([]byte(orderJSON)); err != nil {
-			log.Fatalln("Handler error: RandomOrdersHandler:", err)
-		}
+// Отвечает 200, когда прогрев кэша закончен, и 503, пока он идет.
+// В теле – прогресс прогрева
+func (a *App) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	if !a.warmer.Ready() {
+		status = http.StatusServiceUnavailable
 	}
+	writeJSONStatus(w, r, status, a.warmer.Progress())
 }
 
 func NewApp(driverName, dataSourceName string) (*App, error) {
 	ctx := context.Background()
 
-	cache := c.NewCache()
+	cache, err := c.New()
+	if err != nil {
+		log.Fatalln("Error creating cache:", err)
+	}
 
 	repo, err := repo.NewRepository(driverName, dataSourceName, cache)
 	if err != nil {
 		log.Fatalln("Error creating new repository:", err)
 	}
 
-	latestOrders, err := repo.GetLatestOrders(ctx, repo.Cache.Capacity)
-	if err == nil {
-		repo.Cache.LoadInitialOrders(ctx, latestOrders, repo.Cache.Capacity)
+	changes, err := repo.ListenForChanges(dataSourceName)
+	if err != nil {
+		log.Fatalln("Error listening for order changes:", err)
+	}
+
+	warmupSettings, err := warmup.LoadSettings(repo.Cache.Capacity())
+	if err != nil {
+		log.Fatalln("Invalid cache warmup configuration:", err)
+	}
+	// Кэш заполняется в фоне, HTTP-сервер стартует сразу
+	warmer := warmup.New(repo, warmupSettings)
+	warmer.Start(ctx)
+
+	schemas, err := registry.NewFileRegistry(config.GetString("SCHEMA_REGISTRY_DIR", "schemas"))
+	if err != nil {
+		log.Fatalln("Error opening schema registry:", err)
+	}
+	if err := messages.InitSchemaRegistry(schemas); err != nil {
+		log.Fatalln("Error registering order schema:", err)
+	}
+
+	kafkaConfig, err := k.LoadConfig()
+	if err != nil {
+		log.Fatalln("Invalid Kafka configuration:", err)
+	}
+
+	messageBus, err := newMessageBus(kafkaConfig)
+	if err != nil {
+		log.Fatalln("Error creating message bus:", err)
+	}
+
+	if err := messageBus.EnsureTopics(ctx, kafkaConfig.TopicNames()...); err != nil {
+		log.Fatalln("Error provisioning topics:", err)
+	}
+	publisher := messageBus.Publisher(kafkaConfig.Topic)
+
+	var deadLetters bus.Publisher
+	if kafkaConfig.DeadLetterTopic != "" {
+		deadLetters = messageBus.Publisher(kafkaConfig.DeadLetterTopic)
+	}
+
+	var subscribers []bus.Subscriber
+	storageSettings, err := consumer.BreakerSettings()
+	if err != nil {
+		log.Fatalln("Invalid storage breaker configuration:", err)
+	}
+	storage := breaker.New("storage", storageSettings, repo.Ping)
+	ctl := consumer.NewController(storage, deadLetters)
+	batchSize, batchWait := consumer.BatchSettings()
+	if config.GetBool("KAFKA_OFFSETS_IN_DB", false) {
+		partitions, err := consumer.StartConsumingWithDBOffsets(messageBus, kafkaConfig.Topic, kafkaConfig.GroupID, repo, ctl, batchSize, batchWait)
+		if err != nil {
+			log.Fatalln("Error starting consumer with database offsets:", err)
+		}
+		for _, sub := range partitions {
+			subscribers = append(subscribers, sub)
+		}
 	} else {
-		log.Println("Cache is empty, running on redis:6379")
+		sub, err := messageBus.Subscribe(kafkaConfig.Topic, kafkaConfig.GroupID)
+		if err != nil {
+			log.Fatalln("Error subscribing to topic:", err)
+		}
+		subscribers = append(subscribers, sub)
+		ctl.Register(kafkaConfig.Topic, -1, sub)
+
+		if batchSize > 1 {
+			ctl.Go(func() { consumer.StartBatchConsuming(sub, repo, ctl, batchSize, batchWait) })
+		} else {
+			ctl.Go(func() { consumer.StartConsuming(sub, repo, ctl) })
+		}
 	}
 
-	k.CreateTopic()
-	reader := k.CreateReader()
-	writer := k.CreateWriter()
+	router := consumer.NewRepositoryRouter(repo)
+	for _, topic := range kafkaConfig.EventTopics {
+		// У каждого топика событий своя группа, чтобы перемотка одного
+		// топика не требовала остановки остальных
+		sub, err := messageBus.Subscribe(topic, kafkaConfig.GroupID+"."+topic)
+		if err != nil {
+			log.Fatalln("Error subscribing to topic:", err)
+		}
+		subscribers = append(subscribers, sub)
+		ctl.Register(topic, -1, sub)
 
-	go k.StartConsuming(reader, repo)
+		ctl.Go(func() { consumer.StartRouting(sub, router, ctl) })
+	}
 
-	app := &App{kafkaConsumer: reader, kafkaProducer: writer, repo: repo}
+	app := &App{bus: messageBus, subscribers: subscribers, publisher: publisher, deadLetters: deadLetters, repo: repo, consumer: ctl, warmer: warmer, changes: changes}
 	return app, nil
 }
 
+// Сколько сообщений брокер в памяти хранит в каждом топике
+const defaultMemoryRetention = 10000
+
+// Брокер выбирается переменной MESSAGE_BUS: kafka (по умолчанию)
+// или memory для запуска без Kafka
+func newMessageBus(kafkaConfig *k.Config) (bus.Bus, error) {
+	switch kind := config.GetString("MESSAGE_BUS", "kafka"); kind {
+	case "kafka":
+		return k.NewBus(kafkaConfig), nil
+	case "memory":
+		log.Println("Using in-process message bus, messages are not persisted")
+		return bus.NewMemoryBus(config.GetInt("MEMORY_BUS_RETENTION", defaultMemoryRetention)), nil
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_BUS %q", kind)
+	}
+}
+
 func (a App) Close() {
+	// Прогрев и консьюмеры пишут в бд, поэтому останавливаются до ее
+	// закрытия. Иначе сохраняемые сейчас заказы получили бы ошибку
+	// закрытой бд и ушли бы в DLQ
+	a.warmer.Stop()
+	a.consumer.Stop()
+	for _, sub := range a.subscribers {
+		if err := sub.Close(); err != nil {
+			log.Fatalln("Message stream can't be closed:", err)
+		}
+	}
+
+	if a.changes != nil {
+		if err := a.changes.Close(); err != nil {
+			log.Println("Order change listener can't be closed:", err)
+		}
+	}
+
 	err := a.repo.DB.Close()
 	if err != nil {
 		log.Fatalln("Database connection can't be closed:", err)
 	}
 
-	err = a.repo.Cache.RedisClient.Close()
+	if err := a.warmer.SaveHottest(context.Background()); err != nil {
+		log.Println("Error saving hottest cached orders:", err)
+	}
+
+	err = a.repo.Cache.Close()
 	if err != nil {
 		log.Fatalln("Cache connection can't be closed:", err)
 	}
 
-	err = a.kafkaConsumer.Close()
+	err = a.publisher.Close()
+	if err != nil {
+		log.Fatalln("Message producer can't be closed:", err)
+	}
+
+	if a.deadLetters != nil {
+		if err := a.deadLetters.Close(); err != nil {
+			log.Fatalln("Dead letter producer can't be closed:", err)
+		}
+	}
+
+	err = a.bus.Close()
+	if err != nil {
+		log.Fatalln("Message bus can't be closed:", err)
+	}
+}
+
+// Поднимает только репозиторий и реестр схем, без HTTP-сервера и
+// основного консьюмера, и перечитывает топик
+func Replay(driverName, dataSourceName string, opts k.ReplayOptions) (k.ReplaySummary, error) {
+	ctx := context.Background()
+
+	cache, err := c.New()
 	if err != nil {
-		log.Fatalln("Kafka stream can't be closed:", err)
+		return k.ReplaySummary{}, err
 	}
+	defer cache.Close()
 
-	err = a.kafkaProducer.Close()
+	repo, err := repo.NewRepository(driverName, dataSourceName, cache)
 	if err != nil {
-		log.Fatalln("Kafka producer can't be closed:", err)
+		return k.ReplaySummary{}, err
 	}
+	defer repo.DB.Close()
+
+	schemas, err := registry.NewFileRegistry(config.GetString("SCHEMA_REGISTRY_DIR", "schemas"))
+	if err != nil {
+		return k.ReplaySummary{}, err
+	}
+	if err := messages.InitSchemaRegistry(schemas); err != nil {
+		return k.ReplaySummary{}, err
+	}
+
+	kafkaConfig, err := k.LoadConfig()
+	if err != nil {
+		return k.ReplaySummary{}, err
+	}
+
+	return k.Replay(ctx, kafkaConfig, repo, opts)
 }
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	c "orders/internal/cache"
+	repo "orders/internal/repository"
+	"orders/internal/trace"
+)
+
+// Стабильные коды ошибок API: по ним клиенты различают ошибки, а текст
+// в title и detail может меняться
+const (
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeOrderNotFound    = "order_not_found"
+	codeOrderNotCached   = "order_not_cached"
+	codeInvalidAmount    = "invalid_amount"
+	codeInvalidJSON      = "invalid_json"
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeAdminDisabled    = "admin_disabled"
+	codeSeekFailed       = "seek_failed"
+	codePublishFailed    = "publish_failed"
+	codeUnavailable      = "storage_unavailable"
+	codeInternal         = "internal_error"
+)
+
+// Страницы ошибок для браузеров, остальным клиентам уходит JSON
+var errorPages = map[int]string{
+	http.StatusBadRequest: "web/templates/400.html",
+	http.StatusNotFound:   "web/templates/404.html",
+}
+
+// Ошибка API в формате RFC 7807. RequestID совпадает с X-Trace-Id
+// ответа и строками лога этого запроса
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance"`
+	RequestID string `json:"request_id"`
+}
+
+// Отвечает application/problem+json, а браузеру, который просит
+// text/html, – страницей ошибки, если она есть для этого статуса
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	if page, ok := errorPages[status]; ok && accepts(r.Header.Get("Accept"), "text/html") {
+		html, err := os.ReadFile(page)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			if _, err := w.Write(html); err != nil {
+				log.Println("Error writing response:", err)
+			}
+			return
+		}
+		log.Println("Error reading file:", err)
+	}
+
+	body, err := json.MarshalIndent(problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: trace.FromContext(r.Context()),
+	}, "", "    ")
+	if err != nil {
+		log.Println("Error marshalling JSON:", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// Ответы самого mux – 404 и 405 с текстом – переписываются в
+// problem+json. Ответы обработчиков не трогаются
+func Problems(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(&problemWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type problemWriter struct {
+	http.ResponseWriter
+	r *http.Request
+	// Ответ уже записан как problem, текст mux отбрасывается
+	replaced bool
+}
+
+func (w *problemWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusNotFound:
+		w.replaced = true
+		writeProblem(w.ResponseWriter, w.r, status, codeNotFound, "No such page: "+w.r.URL.Path)
+	case http.StatusMethodNotAllowed:
+		// Allow уже выставлен mux
+		w.replaced = true
+		writeProblem(w.ResponseWriter, w.r, status, codeMethodNotAllowed,
+			fmt.Sprintf("Method %s is not allowed for %s", w.r.Method, w.r.URL.Path))
+	default:
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *problemWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Логирует ошибку и отвечает 503, если бд или кэш недоступны и запрос
+// можно повторить позже, иначе 500. Текст ошибки клиенту не уходит
+func writeServerError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	log.Printf("[trace %s] %s: %v\n", trace.FromContext(r.Context()), msg, err)
+
+	if errors.Is(err, c.ErrUnavailable) || repo.IsStorageFailure(err) {
+		writeProblem(w, r, http.StatusServiceUnavailable, codeUnavailable, "Storage is temporarily unavailable, retry later")
+		return
+	}
+	writeProblem(w, r, http.StatusInternalServerError, codeInternal, "")
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblemsRewritesMuxErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	handler := Problems(mux)
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodPost, "/ready", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{http.MethodGet, "/missing", http.StatusNotFound, codeNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Fatalf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("%s %s: Content-Type %q, want application/problem+json", tt.method, tt.path, ct)
+		}
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		if p.Code != tt.code {
+			t.Errorf("%s %s: code %q, want %q", tt.method, tt.path, p.Code, tt.code)
+		}
+	}
+
+	// Ответ обработчика не переписывается
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.Len() != 0 {
+		t.Fatalf("handler response was rewritten: %d %q", w.Code, w.Body.String())
+	}
+}
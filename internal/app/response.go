@@ -0,0 +1,69 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	c "orders/internal/cache"
+)
+
+// Пишет готовый ответ как есть, в gzip, если клиент его принимает.
+// На If-None-Match с текущим ETag отвечает 304 без тела
+func writeRendered(w http.ResponseWriter, r *http.Request, rendered *c.Rendered) {
+	gzip := accepts(r.Header.Get("Accept-Encoding"), "gzip")
+
+	etag, body := rendered.ETag, rendered.Body
+	if gzip {
+		etag, body = rendered.GzipETag(), rendered.Gzip
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Vary", "Accept-Encoding")
+
+	if etagMatches(r.Header.Get("If-None-Match"), rendered) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if gzip {
+		h.Set("Content-Encoding", "gzip")
+	}
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// Есть ли value с ненулевым q в Accept или Accept-Encoding. Маски
+// вроде */* не учитываются: на них отвечаем форматом по умолчанию
+func accepts(header, value string) bool {
+	for _, part := range strings.Split(header, ",") {
+		item, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(item), value) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// If-None-Match сравнивается слабо, поэтому подходит ETag любого из
+// вариантов: тело у них одно
+func etagMatches(header string, rendered *c.Rendered) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == rendered.ETag || tag == rendered.GzipETag() {
+			return true
+		}
+	}
+	return false
+}
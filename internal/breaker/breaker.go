@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	// Все работает, ошибки только подсчитываются
+	Closed State = "closed"
+	// Слишком много ошибок подряд: обработка остановлена до успешной проверки
+	Open State = "open"
+	// Идет проверка здоровья, обработка все еще остановлена
+	HalfOpen State = "half-open"
+)
+
+const probeTimeout = 5 * time.Second
+
+// Состояния всех breaker'ов доступны в /debug/vars
+var metrics = expvar.NewMap("circuit_breakers")
+
+type Settings struct {
+	// Сколько ошибок подряд размыкают цепь
+	Threshold int
+	// Паузы между проверками здоровья: от MinBackoff, удваиваясь до MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// prefix – префикс переменных окружения, из которых прочитаны настройки,
+// для текста ошибки. Без паузы проверки здоровья шли бы одна за другой
+func (s Settings) Validate(prefix string) error {
+	if s.MinBackoff <= 0 {
+		return fmt.Errorf("%s_MIN_BACKOFF must be positive, got %v", prefix, s.MinBackoff)
+	}
+	if s.MaxBackoff < s.MinBackoff {
+		return fmt.Errorf("%s_MAX_BACKOFF must not be less than %s_MIN_BACKOFF, got %v < %v",
+			prefix, prefix, s.MaxBackoff, s.MinBackoff)
+	}
+	return nil
+}
+
+// Размыкается после Threshold ошибок подряд и сам вызывает probe с
+// растущей паузой, пока проверка не пройдет успешно
+type Breaker struct {
+	name     string
+	settings Settings
+	probe    func(ctx context.Context) error
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	closed   chan struct{}
+
+	stateVar    expvar.String
+	transitions expvar.Int
+	opened      expvar.Int
+}
+
+func New(name string, settings Settings, probe func(ctx context.Context) error) *Breaker {
+	b := &Breaker{
+		name:     name,
+		settings: settings,
+		probe:    probe,
+		state:    Closed,
+		closed:   make(chan struct{}),
+	}
+	close(b.closed)
+
+	m := new(expvar.Map).Init()
+	m.Set("state", &b.stateVar)
+	m.Set("transitions", &b.transitions)
+	m.Set("opened", &b.opened)
+	metrics.Set(name, m)
+	b.stateVar.Set(string(Closed))
+
+	return b
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Канал закрыт, пока цепь замкнута
+func (b *Breaker) Ready() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Closed {
+		b.failures = 0
+	}
+}
+
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Closed {
+		return
+	}
+
+	b.failures++
+	if b.failures < b.settings.Threshold {
+		return
+	}
+
+	log.Printf("Circuit breaker %s: %d failures in a row, last: %v\n", b.name, b.failures, err)
+	b.closed = make(chan struct{})
+	b.opened.Add(1)
+	b.setState(Open)
+	go b.recover()
+}
+
+// Проверяет здоровье с растущей паузой и замыкает цепь после первой
+// успешной проверки
+func (b *Breaker) recover() {
+	backoff := b.settings.MinBackoff
+
+	for {
+		time.Sleep(backoff)
+
+		b.mu.Lock()
+		b.setState(HalfOpen)
+		b.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		err := b.probe(ctx)
+		cancel()
+
+		b.mu.Lock()
+		if err == nil {
+			b.failures = 0
+			b.setState(Closed)
+			close(b.closed)
+			b.mu.Unlock()
+			return
+		}
+
+		log.Printf("Circuit breaker %s: health check failed: %v\n", b.name, err)
+		b.setState(Open)
+		b.mu.Unlock()
+
+		backoff = min(backoff*2, b.settings.MaxBackoff)
+	}
+}
+
+// Вызывается под мьютексом
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	log.Printf("Circuit breaker %s: %s -> %s\n", b.name, b.state, state)
+	b.state = state
+	b.stateVar.Set(string(state))
+	b.transitions.Add(1)
+}
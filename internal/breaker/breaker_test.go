@@ -0,0 +1,75 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("storage is down")
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	var healthy atomic.Bool
+	b := New("test-threshold", Settings{Threshold: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		func(ctx context.Context) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errDown
+		})
+
+	b.Failure(errDown)
+	b.Failure(errDown)
+	if state := b.State(); state != Closed {
+		t.Fatalf("state %s after 2 failures, want %s", state, Closed)
+	}
+
+	b.Failure(errDown)
+	if state := b.State(); state == Closed {
+		t.Fatalf("state %s after 3 failures, want open", state)
+	}
+	select {
+	case <-b.Ready():
+		t.Fatal("Ready is closed while the breaker is open")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	healthy.Store(true)
+	select {
+	case <-b.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("breaker did not close after a successful probe")
+	}
+	if state := b.State(); state != Closed {
+		t.Fatalf("state %s after recovery, want %s", state, Closed)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := New("test-reset", Settings{Threshold: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		func(ctx context.Context) error { return nil })
+
+	b.Failure(errDown)
+	b.Success()
+	b.Failure(errDown)
+	if state := b.State(); state != Closed {
+		t.Fatalf("state %s, want %s: failures were not in a row", state, Closed)
+	}
+}
+
+func TestSettingsRejectsNonPositiveBackoff(t *testing.T) {
+	for _, s := range []Settings{
+		{Threshold: 1, MinBackoff: 0, MaxBackoff: time.Second},
+		{Threshold: 1, MinBackoff: -time.Second, MaxBackoff: time.Second},
+		{Threshold: 1, MinBackoff: time.Second, MaxBackoff: time.Millisecond},
+	} {
+		if err := s.Validate("TEST_BREAKER"); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", s)
+		}
+	}
+	if err := (Settings{Threshold: 1, MinBackoff: time.Second, MaxBackoff: time.Second}).Validate("TEST_BREAKER"); err != nil {
+		t.Errorf("valid settings rejected: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrClosed = errors.New("message bus is closed")
+
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Time      time.Time
+}
+
+func (m Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+type Subscriber interface {
+	// Блокируется до появления нового сообщения или отмены контекста
+	Fetch(ctx context.Context) (Message, error)
+	// Фиксирует сообщения как обработанные для группы подписчика
+	Commit(ctx context.Context, msgs ...Message) error
+	// Переносит чтение партиции на указанный оффсет
+	Seek(ctx context.Context, partition int, offset int64) error
+	// Переносит чтение всех партиций подписки на первое сообщение не
+	// раньше t
+	SeekTime(ctx context.Context, t time.Time) error
+	// Сколько сообщений в подписке еще не обработано
+	Lag(ctx context.Context) (int64, error)
+	Close() error
+}
+
+// Брокер сообщений, через который работают продюсер и консьюмер сервиса
+type Bus interface {
+	EnsureTopics(ctx context.Context, topics ...string) error
+	Publisher(topic string) Publisher
+	// Подписка в составе consumer group с оффсетами на стороне брокера
+	Subscribe(topic, groupID string) (Subscriber, error)
+	// Подписка на каждую партицию без группы, начиная с переданных оффсетов.
+	// Для партиций без оффсета чтение начинается с самого начала.
+	// Возвращает подписчиков по номеру партиции
+	SubscribePartitions(ctx context.Context, topic string, offsets map[int]int64) (map[int]Subscriber, error)
+	Close() error
+}
@@ -0,0 +1,339 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Брокер внутри процесса: у каждого топика одна партиция, сообщения
+// хранятся в памяти. Группы консьюмеров делят между собой общий курсор
+// и помнят закоммиченный оффсет. Сообщения удаляются, когда все группы
+// топика их закоммитили, а подписки без группы прочитали, и при
+// превышении retention сообщений в топике, как при retention в Kafka
+type MemoryBus struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	groups map[string]*memoryGroup
+	// Открытые подписки без группы
+	partitions map[*memorySubscriber]struct{}
+	retention  int
+	notify     chan struct{}
+	closed     bool
+}
+
+type memoryTopic struct {
+	// Оффсет messages[0]
+	first    int64
+	messages []Message
+}
+
+func (t *memoryTopic) end() int64 {
+	return t.first + int64(len(t.messages))
+}
+
+type memoryGroup struct {
+	topic     string
+	next      int64
+	committed int64
+	members   int
+}
+
+// retention – сколько сообщений хранить в топике, 0 – без ограничения
+func NewMemoryBus(retention int) *MemoryBus {
+	return &MemoryBus{
+		topics:     make(map[string]*memoryTopic),
+		groups:     make(map[string]*memoryGroup),
+		partitions: make(map[*memorySubscriber]struct{}),
+		retention:  max(0, retention),
+		notify:     make(chan struct{}),
+	}
+}
+
+// Будит всех, кто ждет новых сообщений. Вызывается под мьютексом
+func (b *MemoryBus) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *MemoryBus) EnsureTopics(ctx context.Context, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		b.topic(topic)
+	}
+	return nil
+}
+
+// Вызывается под мьютексом
+func (b *MemoryBus) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Удаляет сообщения, которые уже никому не нужны. Пока у топика нет ни
+// одной группы и подписки, сообщения ждут их и ограничены только
+// retention. Вызывается под мьютексом
+func (b *MemoryBus) trim(name string) {
+	t := b.topic(name)
+
+	low, readers := t.end(), false
+	for _, group := range b.groups {
+		if group.topic == name {
+			low, readers = min(low, group.committed), true
+		}
+	}
+	for sub := range b.partitions {
+		if sub.topic == name {
+			low, readers = min(low, sub.next), true
+		}
+	}
+	if !readers {
+		low = t.first
+	}
+	if b.retention > 0 {
+		low = max(low, t.end()-int64(b.retention))
+	}
+
+	if n := low - t.first; n > 0 {
+		// Обнуляем удаленные сообщения, чтобы их значения собрал GC, пока
+		// массив еще не переаллоцирован
+		clear(t.messages[:n])
+		t.messages = t.messages[n:]
+		t.first = low
+	}
+}
+
+func (b *MemoryBus) Publisher(topic string) Publisher {
+	return &memoryPublisher{bus: b, topic: topic}
+}
+
+func (b *MemoryBus) Subscribe(topic, groupID string) (Subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	key := groupID + "/" + topic
+	group, ok := b.groups[key]
+	if !ok {
+		group = &memoryGroup{topic: topic, committed: b.topic(topic).first}
+		b.groups[key] = group
+	}
+
+	// Первый участник группы продолжает с закоммиченного оффсета,
+	// поэтому незакоммиченные сообщения будут прочитаны повторно
+	if group.members == 0 {
+		group.next = group.committed
+	}
+	group.members++
+
+	return &memorySubscriber{bus: b, topic: topic, group: group}, nil
+}
+
+func (b *MemoryBus) SubscribePartitions(ctx context.Context, topic string, offsets map[int]int64) (map[int]Subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	t := b.topic(topic)
+	sub := &memorySubscriber{bus: b, topic: topic, next: max(t.first, min(offsets[0], t.end()))}
+	b.partitions[sub] = struct{}{}
+	return map[int]Subscriber{0: sub}, nil
+}
+
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		b.wake()
+	}
+	return nil
+}
+
+type memoryPublisher struct {
+	bus   *MemoryBus
+	topic string
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, msgs ...Message) error {
+	b := p.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	t := b.topic(p.topic)
+	for _, m := range msgs {
+		m.Topic = p.topic
+		m.Partition = 0
+		m.Offset = t.end()
+		m.Time = time.Now()
+		t.messages = append(t.messages, m)
+	}
+	if b.retention > 0 && len(t.messages) > b.retention {
+		b.trim(p.topic)
+	}
+	b.wake()
+	return nil
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
+
+type memorySubscriber struct {
+	bus    *MemoryBus
+	topic  string
+	group  *memoryGroup
+	next   int64
+	closed bool
+}
+
+// Курсор группы общий для всех ее участников, у подписки без группы – свой
+func (s *memorySubscriber) cursor() *int64 {
+	if s.group != nil {
+		return &s.group.next
+	}
+	return &s.next
+}
+
+func (s *memorySubscriber) Fetch(ctx context.Context) (Message, error) {
+	b := s.bus
+
+	for {
+		b.mu.Lock()
+		if b.closed || s.closed {
+			b.mu.Unlock()
+			return Message{}, ErrClosed
+		}
+
+		cursor := s.cursor()
+		t := b.topic(s.topic)
+		// Сообщения, до которых курсор не дошел, могли удалиться по retention
+		*cursor = max(*cursor, t.first)
+		if *cursor < t.end() {
+			m := t.messages[*cursor-t.first]
+			*cursor++
+			if s.group == nil {
+				b.trim(s.topic)
+			}
+			b.mu.Unlock()
+			return m, nil
+		}
+
+		wait := b.notify
+		b.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+func (s *memorySubscriber) Commit(ctx context.Context, msgs ...Message) error {
+	if s.group == nil {
+		return nil
+	}
+
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range msgs {
+		if m.Offset+1 > s.group.committed {
+			s.group.committed = m.Offset + 1
+		}
+	}
+	b.trim(s.topic)
+	return nil
+}
+
+func (s *memorySubscriber) Seek(ctx context.Context, partition int, offset int64) error {
+	if partition != 0 {
+		return fmt.Errorf("partition %d does not exist", partition)
+	}
+
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(s.topic)
+	s.moveTo(max(t.first, min(offset, t.end())))
+	return nil
+}
+
+func (s *memorySubscriber) SeekTime(ctx context.Context, t time.Time) error {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topic := b.topic(s.topic)
+	offset := topic.end()
+	for i, m := range topic.messages {
+		if !m.Time.Before(t) {
+			offset = topic.first + int64(i)
+			break
+		}
+	}
+	s.moveTo(offset)
+	return nil
+}
+
+// Как и в Kafka, перемотка группы меняет и ее закоммиченный оффсет.
+// Удаленные сообщения перемоткой не вернуть. Вызывается под мьютексом
+func (s *memorySubscriber) moveTo(offset int64) {
+	*s.cursor() = offset
+	if s.group != nil {
+		s.group.committed = offset
+	}
+	s.bus.wake()
+}
+
+// Для группы отставание считается от закоммиченного оффсета
+func (s *memorySubscriber) Lag(ctx context.Context) (int64, error) {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(s.topic)
+	from := s.next
+	if s.group != nil {
+		from = s.group.committed
+	}
+	return max(0, t.end()-max(from, t.first)), nil
+}
+
+func (s *memorySubscriber) Close() error {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		if s.group != nil {
+			s.group.members--
+		}
+		delete(b.partitions, s)
+		b.trim(s.topic)
+		b.wake()
+	}
+	return nil
+}
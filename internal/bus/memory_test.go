@@ -0,0 +1,128 @@
+package bus
+
+import (
+	"context"
+	"testing"
+)
+
+func publish(t *testing.T, b *MemoryBus, topic string, n int) {
+	t.Helper()
+
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msgs[i] = Message{Value: []byte{byte(i)}}
+	}
+	if err := b.Publisher(topic).Publish(context.Background(), msgs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fetch(t *testing.T, sub Subscriber) Message {
+	t.Helper()
+
+	m, err := sub.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func stored(b *MemoryBus, topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic].messages)
+}
+
+func TestMemoryBusTrimsCommittedMessages(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBus(0)
+
+	fast, err := b.Subscribe("orders", "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := b.Subscribe("orders", "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "orders", 3)
+
+	for range 3 {
+		if err := fast.Commit(ctx, fetch(t, fast)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := stored(b, "orders"); got != 3 {
+		t.Fatalf("slow group has not committed yet, stored %d messages, want 3", got)
+	}
+
+	m := fetch(t, slow)
+	if err := slow.Commit(ctx, m, fetch(t, slow)); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(b, "orders"); got != 1 {
+		t.Fatalf("stored %d messages, want 1", got)
+	}
+
+	// Оффсеты после удаления не сдвигаются
+	if m := fetch(t, slow); m.Offset != 2 {
+		t.Fatalf("offset %d, want 2", m.Offset)
+	}
+}
+
+func TestMemoryBusRetention(t *testing.T) {
+	b := NewMemoryBus(2)
+
+	sub, err := b.Subscribe("orders", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "orders", 5)
+
+	if got := stored(b, "orders"); got != 2 {
+		t.Fatalf("stored %d messages, want 2", got)
+	}
+	if m := fetch(t, sub); m.Offset != 3 {
+		t.Fatalf("first available offset %d, want 3", m.Offset)
+	}
+	lag, err := sub.Lag(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag != 2 {
+		t.Fatalf("lag %d, want 2", lag)
+	}
+}
+
+func TestMemoryBusKeepsMessagesWithoutReaders(t *testing.T) {
+	b := NewMemoryBus(0)
+	publish(t, b, "orders.dlq", 3)
+
+	if got := stored(b, "orders.dlq"); got != 3 {
+		t.Fatalf("stored %d messages, want 3", got)
+	}
+}
+
+func TestMemoryBusPartitionSubscriberTrimsOnFetch(t *testing.T) {
+	b := NewMemoryBus(0)
+	publish(t, b, "orders", 3)
+
+	subs, err := b.SubscribePartitions(context.Background(), "orders", map[int]int64{0: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := fetch(t, subs[0]); m.Offset != 1 {
+		t.Fatalf("offset %d, want 1", m.Offset)
+	}
+	if got := stored(b, "orders"); got != 1 {
+		t.Fatalf("stored %d messages, want 1", got)
+	}
+
+	// Перемотка назад упирается в первое оставшееся сообщение
+	if err := subs[0].Seek(context.Background(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if m := fetch(t, subs[0]); m.Offset != 2 {
+		t.Fatalf("offset %d, want 2", m.Offset)
+	}
+}
@@ -2,160 +2,129 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
+	"orders/internal/config"
 	g "orders/internal/generator"
-
-	"github.com/redis/go-redis/v9"
 )
 
-type Cache struct {
-	RedisClient *redis.Client
-	Capacity    int32
+var ErrMiss = errors.New("order is not cached")
+
+// Кэш заказов с ограниченной емкостью: при переполнении вытесняются
+// заказы, к которым дольше всего не обращались
+type Cache interface {
+	// Возвращает заказ и отмечает обращение к нему. Если заказа нет – ErrMiss
+	Get(ctx context.Context, uid string) (*g.Order, error)
+	// Как Get, но возвращает готовый ответ GET /orders/{order_uid}
+	GetRendered(ctx context.Context, uid string) (*Rendered, error)
+	// Сохраняет заказы в порядке передачи и вытесняет лишние
+	Set(ctx context.Context, orders ...*g.Order) error
+	Remove(ctx context.Context, uid string) error
+	// Заполняет кэш на старте заказами от самых свежих к старым,
+	// возвращает число загруженных заказов
+	Warm(ctx context.Context, orders []*g.Order) (int, error)
+	// До n order_uid, которые политика вытеснит последними: самые свежие
+	// при LRU и самые популярные при LFU
+	Hottest(ctx context.Context, n int) ([]string, error)
+	Stats(ctx context.Context) (Stats, error)
+	Capacity() int
+	// До n записей, которые вытеснятся первыми (oldest) или последними
+	Entries(ctx context.Context, n int, oldest bool) ([]Entry, error)
+	// Возвращает заказ и его запись, не отмечая обращение. Если заказа
+	// нет – ErrMiss
+	Inspect(ctx context.Context, uid string) (*g.Order, Entry, error)
+	// Удаляет все заказы, возвращает их число
+	Flush(ctx context.Context) (int, error)
+	// Меняет емкость и сразу вытесняет лишнее, возвращает число
+	// вытесненных заказов
+	Resize(ctx context.Context, capacity int) (int, error)
+	Ping(ctx context.Context) error
+	Close() error
 }
 
-const cacheCapacity int32 = 200
-
-func NewCache() *Cache {
-	rURL := os.Getenv("REDIS_CONN_STRING")
-
-	opt, err := redis.ParseURL(rURL)
-	if err != nil {
-		log.Fatalln("Error connecting to redis:", err)
-	}
-
-	rdb := redis.NewClient(opt)
-	return &Cache{RedisClient: rdb, Capacity: cacheCapacity}
+// Распределенная блокировка загрузки заказа в кэш, чтобы при промахе
+// в бд ходила только одна реплика. Реализована только для Redis
+type Locker interface {
+	// Пытается занять блокировку на ttl. Если она уже занята, ok равен false
+	Lock(ctx context.Context, uid string, ttl time.Duration) (unlock func(), ok bool, err error)
 }
 
-func (c *Cache) LoadInitialOrders(ctx context.Context, latestOrders []*g.Order, limit int32) {
-	var successfulOrders int
-
-	for _, order := range latestOrders {
-		orderJSON, err := json.Marshal(order)
-		if err != nil {
-			log.Println("Error marshalling order to JSON:", err)
-			continue
-		}
-
-		redisKey := order.OrderUID
-		err = c.AddToCache(ctx, redisKey, orderJSON)
-		if err != nil {
-			log.Printf("Error adding order with uid %s\n", redisKey)
-			continue
-		}
-
-		err = c.UpdateLRU(ctx, redisKey)
-		if err != nil {
-			log.Printf("Error updating LRU with order with uid %s\n", redisKey)
-			continue
-		}
-
-		successfulOrders++
-	}
-	log.Printf("Cache filled with %d/%d orders, running on redis:6379\n", successfulOrders, cacheCapacity)
+type Stats struct {
+	Backend  string `json:"backend"`
+	Policy   Policy `json:"policy"`
+	Size     int64  `json:"size"`
+	Capacity int    `json:"capacity"`
+	// Суммарный размер заказов, если он отслеживается
+	Bytes     int64 `json:"bytes,omitempty"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	// Кэш внутри процесса перед Redis, если он включен
+	L1 *Stats `json:"l1,omitempty"`
 }
 
-func (c *Cache) AddToCache(ctx context.Context, redisKey string, orderJSON []byte) error {
-	err := c.RedisClient.Set(ctx, redisKey, orderJSON, 0).Err()
-	if err != nil {
-		log.Println("Error adding order to Redis:", err)
-		return err
-	}
-	return nil
+// Запись кэша для админского API. Score – место в очереди вытеснения:
+// чем меньше, тем раньше заказ вытеснится. В Redis это время последнего
+// обращения в мс, а при LFU – число обращений
+type Entry struct {
+	UID   string  `json:"order_uid"`
+	Score float64 `json:"score"`
+	Bytes int     `json:"bytes,omitempty"`
 }
 
-func (c *Cache) UpdateLRU(ctx context.Context, uid string) error {
-	zKey := "LRU-orders"
+// Счетчики обращений, общие для всех реализаций
+type counters struct {
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
 
-	now := float64(time.Now().UnixMilli())
-	err := c.RedisClient.ZAdd(ctx, zKey, redis.Z{
-		Member: uid,
-		Score:  now,
-	}).Err()
-	if err != nil {
-		log.Println("Error adding to ZSET:")
-		return err
+func (c *counters) stats(backend string, opts Options, size, bytes int64) Stats {
+	return Stats{
+		Backend:   backend,
+		Policy:    opts.Policy,
+		Size:      size,
+		Capacity:  opts.Capacity,
+		Bytes:     bytes,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
 	}
+}
 
-	currentCapacity, err := c.RedisClient.ZCard(ctx, zKey).Result()
+// Реализация выбирается переменной CACHE_BACKEND: redis (по умолчанию)
+// или memory для запуска без Redis. CACHE_L1_CAPACITY > 0 добавляет
+// перед Redis кэш внутри процесса на столько заказов. Redis всегда
+// работает за breaker'ом, см. FailoverCache
+func New() (Cache, error) {
+	opts, err := LoadOptions()
 	if err != nil {
-		log.Println("Error getting cache capacity:")
-		return err
+		return nil, err
 	}
 
-	for currentCapacity > int64(cacheCapacity) {
-		members, err := c.RedisClient.ZPopMin(ctx, zKey, 1).Result()
+	switch backend := config.GetString("CACHE_BACKEND", "redis"); backend {
+	case "redis":
+		settings, err := BreakerSettings()
 		if err != nil {
-			log.Println("Error removing order with lowest score:", err)
-			return err
+			return nil, err
 		}
-		currentCapacity--
-
-		lowestScoreUid := members[0].Member.(string)
-		err = c.RemoveFromCache(ctx, lowestScoreUid)
+		client, err := NewRedisClient()
 		if err != nil {
-			return err
+			return nil, err
 		}
-	}
-	return nil
-}
+		redisCache := NewRedisCache(client, config.GetString("CACHE_KEY_PREFIX", "orders:"), opts)
 
-func (c *Cache) RemoveFromCache(ctx context.Context, uid string) error {
-	err := c.RedisClient.Del(ctx, uid).Err()
-	if err != nil {
-		log.Printf("Error removing order with uid %s from cache: %d\n", uid, err)
-		return err
-	}
-	return nil
-}
-
-func (c *Cache) GetFromCache(ctx context.Context, uid string) (*g.Order, error) {
-	cmd := c.RedisClient.Get(ctx, uid)
-	if cmd.Err() != nil {
-		log.Println("Can't find cached data for", uid)
-		return nil, cmd.Err()
-	}
-
-	orderJSON, err := cmd.Bytes()
-	if err != nil {
-		log.Println("Error marshalling cached data for", uid)
-		return nil, err
-	}
-
-	var order g.Order
-	err = json.Unmarshal(orderJSON, &order)
-	if err != nil {
-		log.Println("Error marshalling cached data for", uid)
-		return nil, err
-	}
-
-	err = c.UpdateLRU(ctx, uid)
-	if err != nil {
-		return nil, err
-	}
-	return &order, nil
-}
-
-func (c *Cache) UpdateCache(ctx context.Context, order *g.Order) error {
-	orderJSON, err := json.Marshal(order)
-	if err != nil {
-		log.Println("Error marshalling order before adding to cache:", err)
-		return err
-	}
-
-	redisKey := order.OrderUID
-	err = c.AddToCache(ctx, redisKey, orderJSON)
-	if err != nil {
-		return err
-	}
-
-	err = c.UpdateLRU(ctx, redisKey)
-	if err != nil {
-		return err
+		var cache Cache = redisCache
+		if l1 := config.GetInt("CACHE_L1_CAPACITY", 0); l1 > 0 {
+			cache = NewTieredCache(redisCache, l1)
+		}
+		return NewFailoverCache(cache, settings), nil
+	case "memory":
+		return NewMemoryCache(opts), nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", backend)
 	}
-	return nil
 }
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"fmt"
+
+	"orders/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Подключение к Redis выбирается переменной REDIS_MODE:
+// single (по умолчанию) – один узел по REDIS_CONN_STRING,
+// sentinel – мастер REDIS_SENTINEL_MASTER через REDIS_SENTINEL_ADDRS,
+// cluster – узлы кластера REDIS_CLUSTER_ADDRS
+func NewRedisClient() (redis.UniversalClient, error) {
+	switch mode := config.GetString("REDIS_MODE", "single"); mode {
+	case "single":
+		opt, err := redis.ParseURL(config.GetString("REDIS_CONN_STRING", ""))
+		if err != nil {
+			return nil, fmt.Errorf("parsing REDIS_CONN_STRING: %w", err)
+		}
+		return redis.NewClient(opt), nil
+	case "sentinel":
+		opt := &redis.FailoverOptions{
+			MasterName:       config.GetString("REDIS_SENTINEL_MASTER", ""),
+			SentinelAddrs:    config.GetList("REDIS_SENTINEL_ADDRS", nil),
+			SentinelPassword: config.GetString("REDIS_SENTINEL_PASSWORD", ""),
+			Password:         config.GetString("REDIS_PASSWORD", ""),
+			DB:               config.GetInt("REDIS_DB", 0),
+		}
+		if opt.MasterName == "" || len(opt.SentinelAddrs) == 0 {
+			return nil, fmt.Errorf("REDIS_MODE=sentinel requires REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS")
+		}
+		return redis.NewFailoverClient(opt), nil
+	case "cluster":
+		opt := &redis.ClusterOptions{
+			Addrs:    config.GetList("REDIS_CLUSTER_ADDRS", nil),
+			Password: config.GetString("REDIS_PASSWORD", ""),
+		}
+		if len(opt.Addrs) == 0 {
+			return nil, fmt.Errorf("REDIS_MODE=cluster requires REDIS_CLUSTER_ADDRS")
+		}
+		return redis.NewClusterClient(opt), nil
+	default:
+		return nil, fmt.Errorf("unknown REDIS_MODE %q", mode)
+	}
+}
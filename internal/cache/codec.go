@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"orders/internal/config"
+	g "orders/internal/generator"
+	"orders/internal/orderproto"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Format byte
+
+// Первый байт значения в кэше – формат. Значения без префикса записаны
+// до появления кодеков и начинаются с '{' – они читаются как JSON
+const (
+	FormatJSON  Format = 1
+	FormatProto Format = 2
+	// Готовый ответ API, см. Rendered. Не сжимается zstd: в нем уже
+	// есть gzip-вариант
+	FormatResponse Format = 3
+
+	// Старший бит префикса – значение сжато zstd
+	compressedFlag byte = 0x80
+	legacyJSON     byte = '{'
+)
+
+// Кодирует заказы для хранения в кэше. Заказы больше CompressAbove байт
+// сжимаются zstd, 0 – не сжимать
+type Codec struct {
+	Format        Format
+	CompressAbove int
+}
+
+// zstd-кодировщики потокобезопасны для EncodeAll/DecodeAll, поэтому
+// создаются один раз
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+)
+
+// Читает CACHE_ENCODING (response по умолчанию, json или proto)
+// и CACHE_COMPRESS_ABOVE
+func LoadCodec() (Codec, error) {
+	codec := Codec{CompressAbove: config.GetInt("CACHE_COMPRESS_ABOVE", 0)}
+
+	switch encoding := config.GetString("CACHE_ENCODING", "response"); encoding {
+	case "json":
+		codec.Format = FormatJSON
+	case "proto":
+		codec.Format = FormatProto
+	case "response":
+		codec.Format = FormatResponse
+	default:
+		return Codec{}, fmt.Errorf("unknown CACHE_ENCODING %q", encoding)
+	}
+	if codec.CompressAbove < 0 {
+		return Codec{}, fmt.Errorf("CACHE_COMPRESS_ABOVE must not be negative, got %d", codec.CompressAbove)
+	}
+
+	// Сжатые значения могут остаться в кэше и после выключения сжатия,
+	// поэтому декодер нужен всегда
+	if _, err := zstdDecoder(); err != nil {
+		return Codec{}, fmt.Errorf("creating zstd decoder: %w", err)
+	}
+	if codec.CompressAbove > 0 {
+		if _, err := zstdEncoder(); err != nil {
+			return Codec{}, fmt.Errorf("creating zstd encoder: %w", err)
+		}
+	}
+	return codec, nil
+}
+
+func (c Codec) Encode(order *g.Order) ([]byte, error) {
+	var body []byte
+	switch c.Format {
+	case FormatResponse:
+		rendered, err := Render(order)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{byte(FormatResponse)}, rendered.marshal()...), nil
+	case FormatProto:
+		body = orderproto.MarshalOrders([]*g.Order{order})
+	default:
+		var err error
+		if body, err = json.Marshal(order); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := byte(c.Format)
+	if prefix == 0 {
+		prefix = byte(FormatJSON)
+	}
+	if c.CompressAbove > 0 && len(body) > c.CompressAbove {
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		prefix |= compressedFlag
+		body = enc.EncodeAll(body, nil)
+	}
+
+	data := make([]byte, 0, len(body)+1)
+	data = append(data, prefix)
+	return append(data, body...), nil
+}
+
+// Формат берется из префикса, а не из настроек, поэтому при смене
+// CACHE_ENCODING старые значения остаются читаемыми
+func (c Codec) Decode(data []byte) (*g.Order, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty cached value")
+	}
+	if data[0] == legacyJSON {
+		return decodeJSON(data)
+	}
+
+	format, body := Format(data[0]&^compressedFlag), data[1:]
+	if data[0]&compressedFlag != 0 {
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		if body, err = dec.DecodeAll(body, nil); err != nil {
+			return nil, fmt.Errorf("decompressing cached value: %w", err)
+		}
+	}
+
+	switch format {
+	case FormatJSON:
+		return decodeJSON(body)
+	case FormatProto:
+		orders, err := orderproto.UnmarshalOrders(body)
+		if err != nil {
+			return nil, err
+		}
+		if len(orders) != 1 {
+			return nil, fmt.Errorf("cached value holds %d orders, expected 1", len(orders))
+		}
+		return orders[0], nil
+	case FormatResponse:
+		rendered, err := unmarshalRendered(body)
+		if err != nil {
+			return nil, err
+		}
+		return decodeJSON(rendered.Body)
+	default:
+		return nil, fmt.Errorf("unknown cached value format %d", data[0])
+	}
+}
+
+// Готовый ответ хранится как есть, остальные форматы декодируются
+// и рендерятся заново
+func (c Codec) Rendered(data []byte) (*Rendered, error) {
+	if len(data) > 0 && data[0] == byte(FormatResponse) {
+		return unmarshalRendered(data[1:])
+	}
+
+	order, err := c.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return Render(order)
+}
+
+func decodeJSON(data []byte) (*g.Order, error) {
+	var order g.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
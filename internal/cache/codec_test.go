@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	order := testOrder("round-trip")
+	want, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{FormatJSON, FormatProto, FormatResponse} {
+		for _, compressAbove := range []int{0, 1} {
+			codec := Codec{Format: format, CompressAbove: compressAbove}
+			t.Run(fmt.Sprintf("format %d compress above %d", format, compressAbove), func(t *testing.T) {
+				data, err := codec.Encode(order)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := codec.Decode(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := json.Marshal(decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("decoded order differs:\n got %s\nwant %s", got, want)
+				}
+
+				rendered, err := codec.Rendered(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				direct, err := Render(order)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(rendered.Body) != string(direct.Body) || rendered.ETag != direct.ETag {
+					t.Errorf("rendered response differs from Render")
+				}
+			})
+		}
+	}
+}
+
+// Значения, записанные до появления кодеков, – JSON без префикса
+func TestCodecDecodesLegacyJSON(t *testing.T) {
+	order := testOrder("legacy")
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Codec{Format: FormatProto}.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.OrderUID != order.OrderUID {
+		t.Fatalf("order_uid %q, want %q", decoded.OrderUID, order.OrderUID)
+	}
+}
+
+func TestCodecRejectsMalformedValues(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x7f}, {byte(FormatResponse), 200}} {
+		if _, err := (Codec{}).Decode(data); err == nil {
+			t.Errorf("Decode(%v) succeeded, want error", data)
+		}
+	}
+}
+
+var benchFormats = []struct {
+	name  string
+	codec Codec
+}{
+	{"json", Codec{Format: FormatJSON}},
+	{"json-zstd", Codec{Format: FormatJSON, CompressAbove: 1}},
+	{"proto", Codec{Format: FormatProto}},
+	{"proto-zstd", Codec{Format: FormatProto, CompressAbove: 1}},
+	{"response", Codec{Format: FormatResponse}},
+}
+
+func BenchmarkEncode(b *testing.B) {
+	order := testOrder("bench")
+	for _, f := range benchFormats {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var size int
+			for range b.N {
+				data, err := f.codec.Encode(order)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "encoded-bytes")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	order := testOrder("bench")
+	for _, f := range benchFormats {
+		b.Run(f.name, func(b *testing.B) {
+			data, err := f.codec.Encode(order)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := f.codec.Decode(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "encoded-bytes")
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"orders/internal/breaker"
+	"orders/internal/config"
+	g "orders/internal/generator"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Кэш временно недоступен, например пока Sentinel переключает мастер.
+// Чтения в этом случае идут в бд
+var ErrUnavailable = errors.New("cache is unavailable")
+
+// Больше стольких заказов не запоминается, дальше кэш очищается целиком
+const maxDirty = 10000
+
+// Кэш за breaker'ом: после CACHE_BREAKER_THRESHOLD ошибок доступности
+// подряд все вызовы сразу возвращают ErrUnavailable, а не ждут таймаута
+// Redis. Заказы, которые за это время не удалось записать или удалить,
+// могут остаться в кэше устаревшими, поэтому они удаляются до того,
+// как цепь снова замкнется. Состояние видно в метрике circuit_breakers
+type FailoverCache struct {
+	Cache
+	breaker *breaker.Breaker
+
+	mu       sync.Mutex
+	dirty    map[string]struct{}
+	dirtyAll bool
+	pending  atomic.Bool
+	purging  sync.Mutex
+}
+
+// Читает CACHE_BREAKER_THRESHOLD, CACHE_BREAKER_MIN_BACKOFF
+// и CACHE_BREAKER_MAX_BACKOFF
+func BreakerSettings() (breaker.Settings, error) {
+	s := breaker.Settings{
+		Threshold:  max(1, config.GetInt("CACHE_BREAKER_THRESHOLD", 3)),
+		MinBackoff: config.GetDuration("CACHE_BREAKER_MIN_BACKOFF", time.Second),
+		MaxBackoff: config.GetDuration("CACHE_BREAKER_MAX_BACKOFF", 30*time.Second),
+	}
+	return s, s.Validate("CACHE_BREAKER")
+}
+
+func NewFailoverCache(inner Cache, settings breaker.Settings) *FailoverCache {
+	c := &FailoverCache{Cache: inner, dirty: make(map[string]struct{})}
+	c.breaker = breaker.New("cache", settings, c.probe)
+	return c
+}
+
+// Отличает недоступность Redis от промахов и ошибок в данных:
+// обрывы соединения, таймауты и ответы узла, который сейчас не может
+// обслуживать запросы (загрузка, реплика, переключение мастера)
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, ErrUnavailable) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, redis.ErrPoolTimeout) ||
+		errors.Is(err, redis.ErrClosed) ||
+		redis.HasErrorPrefix(err, "LOADING") ||
+		redis.HasErrorPrefix(err, "READONLY") ||
+		redis.HasErrorPrefix(err, "MASTERDOWN") ||
+		redis.HasErrorPrefix(err, "CLUSTERDOWN") ||
+		redis.HasErrorPrefix(err, "TRYAGAIN")
+}
+
+func (c *FailoverCache) Get(ctx context.Context, uid string) (*g.Order, error) {
+	return call(c, func() (*g.Order, error) { return c.Cache.Get(ctx, uid) })
+}
+
+func (c *FailoverCache) GetRendered(ctx context.Context, uid string) (*Rendered, error) {
+	return call(c, func() (*Rendered, error) { return c.Cache.GetRendered(ctx, uid) })
+}
+
+func (c *FailoverCache) Set(ctx context.Context, orders ...*g.Order) error {
+	_, err := call(c, func() (struct{}, error) { return struct{}{}, c.Cache.Set(ctx, orders...) })
+	if IsUnavailable(err) {
+		uids := make([]string, 0, len(orders))
+		for _, order := range orders {
+			uids = append(uids, order.OrderUID)
+		}
+		c.markDirty(uids...)
+	}
+	return err
+}
+
+func (c *FailoverCache) Remove(ctx context.Context, uid string) error {
+	_, err := call(c, func() (struct{}, error) { return struct{}{}, c.Cache.Remove(ctx, uid) })
+	if IsUnavailable(err) {
+		c.markDirty(uid)
+	}
+	return err
+}
+
+func (c *FailoverCache) Warm(ctx context.Context, orders []*g.Order) (int, error) {
+	return call(c, func() (int, error) { return c.Cache.Warm(ctx, orders) })
+}
+
+func (c *FailoverCache) Hottest(ctx context.Context, n int) ([]string, error) {
+	return call(c, func() ([]string, error) { return c.Cache.Hottest(ctx, n) })
+}
+
+func (c *FailoverCache) Stats(ctx context.Context) (Stats, error) {
+	return call(c, func() (Stats, error) { return c.Cache.Stats(ctx) })
+}
+
+func (c *FailoverCache) Entries(ctx context.Context, n int, oldest bool) ([]Entry, error) {
+	return call(c, func() ([]Entry, error) { return c.Cache.Entries(ctx, n, oldest) })
+}
+
+func (c *FailoverCache) Inspect(ctx context.Context, uid string) (*g.Order, Entry, error) {
+	var entry Entry
+	order, err := call(c, func() (*g.Order, error) {
+		order, e, err := c.Cache.Inspect(ctx, uid)
+		entry = e
+		return order, err
+	})
+	return order, entry, err
+}
+
+func (c *FailoverCache) Flush(ctx context.Context) (int, error) {
+	return call(c, func() (int, error) { return c.Cache.Flush(ctx) })
+}
+
+func (c *FailoverCache) Resize(ctx context.Context, capacity int) (int, error) {
+	return call(c, func() (int, error) { return c.Cache.Resize(ctx, capacity) })
+}
+
+func (c *FailoverCache) Lock(ctx context.Context, uid string, ttl time.Duration) (func(), bool, error) {
+	locker, ok := c.Cache.(Locker)
+	if !ok {
+		return func() {}, true, nil
+	}
+
+	var unlock func()
+	acquired, err := call(c, func() (bool, error) {
+		u, acquired, err := locker.Lock(ctx, uid, ttl)
+		unlock = u
+		return acquired, err
+	})
+	return unlock, acquired, err
+}
+
+// Пока цепь разомкнута, внутренний кэш не вызывается. Ошибки
+// доступности размыкают цепь, любой другой ответ (в том числе промах)
+// считается успехом
+func call[T any](c *FailoverCache, f func() (T, error)) (T, error) {
+	var zero T
+	if c.breaker.State() != breaker.Closed {
+		return zero, ErrUnavailable
+	}
+
+	v, err := f()
+	if IsUnavailable(err) {
+		c.breaker.Failure(err)
+		return zero, err
+	}
+	c.breaker.Success()
+
+	// Ошибка была единичной, и цепь не размыкалась: устаревшие заказы
+	// удаляются при первом успешном обращении
+	if c.pending.Load() && c.purging.TryLock() {
+		go func() {
+			defer c.purging.Unlock()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.purge(ctx); err != nil {
+				log.Println("Error removing stale cached orders:", err)
+			}
+		}()
+	}
+	return v, err
+}
+
+func (c *FailoverCache) markDirty(uids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, uid := range uids {
+		if len(c.dirty) >= maxDirty {
+			c.dirtyAll = true
+			clear(c.dirty)
+			break
+		}
+		c.dirty[uid] = struct{}{}
+	}
+	c.pending.Store(true)
+}
+
+// Проверка здоровья: Redis отвечает, и устаревшие заказы удалены
+func (c *FailoverCache) probe(ctx context.Context) error {
+	if err := c.Cache.Ping(ctx); err != nil {
+		return err
+	}
+	c.purging.Lock()
+	defer c.purging.Unlock()
+	return c.purge(ctx)
+}
+
+// Вызывается под c.purging
+func (c *FailoverCache) purge(ctx context.Context) error {
+	c.mu.Lock()
+	all, uids := c.dirtyAll, make([]string, 0, len(c.dirty))
+	for uid := range c.dirty {
+		uids = append(uids, uid)
+	}
+	c.mu.Unlock()
+
+	if all {
+		flushed, err := c.Cache.Flush(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Cache flushed after outage, too many orders changed: %d removed\n", flushed)
+		c.mu.Lock()
+		c.dirtyAll = false
+		c.pending.Store(len(c.dirty) > 0)
+		c.mu.Unlock()
+		return nil
+	}
+
+	for _, uid := range uids {
+		if err := c.Cache.Remove(ctx, uid); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		delete(c.dirty, uid)
+		c.mu.Unlock()
+	}
+	if len(uids) > 0 {
+		log.Printf("Removed %d orders changed while cache was unavailable\n", len(uids))
+	}
+
+	c.mu.Lock()
+	c.pending.Store(c.dirtyAll || len(c.dirty) > 0)
+	c.mu.Unlock()
+	return nil
+}
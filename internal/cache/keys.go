@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Раскладка ключей в Redis. Все ключи начинаются с {CACHE_KEY_PREFIX},
+// а ключ заказа еще и содержит версию схемы generator.Order: после
+// изменения структуры старые значения просто не находятся, заказы
+// заново читаются из бд, а старые ключи вытесняются как давно не
+// читанные
+type keyspace struct {
+	prefix  string
+	version int
+}
+
+// Префикс берется в фигурные скобки: в Redis Cluster все ключи тогда
+// попадают в один слот, и Lua-скрипты, которым нужны ZSET и ключи
+// заказов сразу, продолжают работать. Префикс, в котором уже есть
+// хэш-тег, остается как есть
+func newKeyspace(prefix string, version int) keyspace {
+	if !hasHashTag(prefix) {
+		prefix = "{" + prefix + "}"
+	}
+	return keyspace{prefix: prefix, version: version}
+}
+
+func hasHashTag(key string) bool {
+	open := strings.Index(key, "{")
+	return open >= 0 && strings.Index(key[open+1:], "}") > 0
+}
+
+func (k keyspace) lru() string   { return k.prefix + "lru" }
+func (k keyspace) sizes() string { return k.prefix + "lru-sizes" }
+func (k keyspace) bytes() string { return k.prefix + "lru-bytes" }
+
+// Емкость, измененная через админский API, общая для всех реплик
+func (k keyspace) capacity() string { return k.prefix + "lru-capacity" }
+
+func (k keyspace) lock(uid string) string { return k.prefix + "lock:" + uid }
+
+// Канал рассылки изменений для двухуровневого кэша
+func (k keyspace) invalidations() string { return k.prefix + "invalidate" }
+
+func (k keyspace) orders() string {
+	return k.prefix + "v" + strconv.Itoa(k.version) + ":"
+}
+
+func (k keyspace) order(uid string) string {
+	return k.orders() + uid
+}
+
+func (k keyspace) orderKeys(uids []string) []string {
+	keys := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		keys = append(keys, k.order(uid))
+	}
+	return keys
+}
+
+// order_uid из ключа заказа. Для ключей другой версии схемы ok равен false
+func (k keyspace) uid(key string) (string, bool) {
+	return strings.CutPrefix(key, k.orders())
+}
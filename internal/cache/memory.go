@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	g "orders/internal/generator"
+)
+
+// Кэш внутри процесса для запуска без Redis. Заказы хранятся
+// закодированными, поэтому изменение полученного заказа не меняет кэш
+type MemoryCache struct {
+	mu      sync.Mutex
+	opts    Options
+	entries map[string]*memoryEntry
+	// На вершине кучи – первый кандидат на вытеснение
+	queue entryQueue
+	// Порядковый номер последнего обращения
+	seq   uint64
+	bytes int64
+	counters
+}
+
+type memoryEntry struct {
+	uid     string
+	data    []byte
+	seq     uint64
+	count   int64
+	expires time.Time
+	index   int
+}
+
+func NewMemoryCache(opts Options) *MemoryCache {
+	return &MemoryCache{
+		opts:    opts,
+		entries: make(map[string]*memoryEntry),
+		queue:   entryQueue{lfu: opts.Policy == PolicyLFU},
+	}
+}
+
+func (c *MemoryCache) Capacity() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opts.Capacity
+}
+
+func (c *MemoryCache) Get(ctx context.Context, uid string) (*g.Order, error) {
+	data, err := c.get(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := c.opts.Codec.Decode(data)
+	if err != nil {
+		log.Println("Error marshalling cached data for", uid)
+		return nil, err
+	}
+	c.hits.Add(1)
+	return order, nil
+}
+
+func (c *MemoryCache) GetRendered(ctx context.Context, uid string) (*Rendered, error) {
+	data, err := c.get(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := c.opts.Codec.Rendered(data)
+	if err != nil {
+		log.Println("Error marshalling cached data for", uid)
+		return nil, err
+	}
+	c.hits.Add(1)
+	return rendered, nil
+}
+
+// Закодированный заказ с отметкой обращения к нему
+func (c *MemoryCache) get(uid string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[uid]
+	if ok && c.expired(e, time.Now()) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, ErrMiss
+	}
+	c.touch(e, true)
+	return e.data, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, orders ...*g.Order) error {
+	for _, order := range orders {
+		data, err := c.opts.Codec.Encode(order)
+		if err != nil {
+			log.Println("Error marshalling order before adding to cache:", err)
+			continue
+		}
+		c.set(order.OrderUID, data)
+	}
+	return nil
+}
+
+func (c *MemoryCache) set(uid string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[uid]; ok {
+		c.bytes += int64(len(data) - len(e.data))
+		e.data = data
+		c.touch(e, false)
+	} else {
+		e := &memoryEntry{uid: uid, data: data, count: c.admitCount()}
+		c.entries[uid] = e
+		c.bytes += int64(len(data))
+		heap.Push(&c.queue, e)
+		c.touch(e, false)
+	}
+	c.trim()
+}
+
+// Счетчик обращений нового заказа. При LFU место для него освобождается
+// заранее, и он получает счетчик наименее используемого из оставшихся,
+// иначе со счетчиком 1 он вытеснялся бы первым. Вызывается под мьютексом
+func (c *MemoryCache) admitCount() int64 {
+	if !c.queue.lfu {
+		return 1
+	}
+
+	for c.queue.Len() > 0 && c.queue.Len() >= c.opts.Capacity {
+		c.remove(c.queue.items[0])
+		c.evictions.Add(1)
+	}
+	if c.queue.Len() == 0 {
+		return 1
+	}
+	return c.queue.items[0].count
+}
+
+// Отмечает обращение к заказу. Запись заказа не считается обращением
+// для LFU. Вызывается под мьютексом
+func (c *MemoryCache) touch(e *memoryEntry, access bool) {
+	c.seq++
+	e.seq = c.seq
+	if access {
+		e.count++
+	}
+	if c.opts.Policy == PolicyTTL {
+		e.expires = time.Now().Add(c.opts.TTL)
+	}
+	heap.Fix(&c.queue, e.index)
+}
+
+func (c *MemoryCache) expired(e *memoryEntry, now time.Time) bool {
+	return c.opts.Policy == PolicyTTL && now.After(e.expires)
+}
+
+// Вытесняет заказы, пока кэш не уложится в ограничения. При TTL срок
+// продлевается при каждом обращении, поэтому истекшие заказы всегда
+// лежат на вершине кучи. Вызывается под мьютексом
+func (c *MemoryCache) trim() {
+	now := time.Now()
+	for c.queue.Len() > 0 {
+		top := c.queue.items[0]
+		over := c.queue.Len() > c.opts.Capacity ||
+			(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)
+		if !over && !c.expired(top, now) {
+			return
+		}
+		c.remove(top)
+		c.evictions.Add(1)
+	}
+}
+
+// Вызывается под мьютексом
+func (c *MemoryCache) remove(e *memoryEntry) {
+	heap.Remove(&c.queue, e.index)
+	delete(c.entries, e.uid)
+	c.bytes -= int64(len(e.data))
+}
+
+func (c *MemoryCache) Remove(ctx context.Context, uid string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[uid]; ok {
+		c.remove(e)
+	}
+	return nil
+}
+
+// Заказы приходят от самых свежих к старым, поэтому добавляются с конца
+func (c *MemoryCache) Warm(ctx context.Context, orders []*g.Order) (int, error) {
+	for i := len(orders) - 1; i >= 0; i-- {
+		if err := c.Set(ctx, orders[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	c.mu.Lock()
+	size := c.queue.Len()
+	c.mu.Unlock()
+
+	return size, nil
+}
+
+func (c *MemoryCache) Hottest(ctx context.Context, n int) ([]string, error) {
+	entries, err := c.Entries(ctx, n, false)
+	if err != nil {
+		return nil, err
+	}
+
+	uids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		uids = append(uids, e.UID)
+	}
+	return uids, nil
+}
+
+func (c *MemoryCache) Entries(ctx context.Context, n int, oldest bool) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Сортируется копия кучи, индексы записей не меняются
+	q := entryQueue{items: slices.Clone(c.queue.items), lfu: c.queue.lfu}
+	sort.Slice(q.items, func(i, j int) bool {
+		if oldest {
+			return q.Less(i, j)
+		}
+		return q.Less(j, i)
+	})
+
+	entries := make([]Entry, 0, min(n, q.Len()))
+	for _, e := range q.items[:min(n, q.Len())] {
+		entries = append(entries, c.entry(e))
+	}
+	return entries, nil
+}
+
+// Score – номер последнего обращения, а при LFU – число обращений.
+// Вызывается под мьютексом
+func (c *MemoryCache) entry(e *memoryEntry) Entry {
+	score := float64(e.seq)
+	if c.queue.lfu {
+		score = float64(e.count)
+	}
+	return Entry{UID: e.uid, Score: score, Bytes: len(e.data)}
+}
+
+func (c *MemoryCache) Inspect(ctx context.Context, uid string) (*g.Order, Entry, error) {
+	c.mu.Lock()
+	e, ok := c.entries[uid]
+	if !ok || c.expired(e, time.Now()) {
+		c.mu.Unlock()
+		return nil, Entry{}, ErrMiss
+	}
+	entry, data := c.entry(e), e.data
+	c.mu.Unlock()
+
+	order, err := c.opts.Codec.Decode(data)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	return order, entry, nil
+}
+
+func (c *MemoryCache) Flush(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flushed := c.queue.Len()
+	clear(c.entries)
+	c.queue.items = nil
+	c.bytes = 0
+	return flushed, nil
+}
+
+func (c *MemoryCache) Resize(ctx context.Context, capacity int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := c.evictions.Load()
+	c.opts.Capacity = capacity
+	c.trim()
+	return int(c.evictions.Load() - before), nil
+}
+
+func (c *MemoryCache) Stats(ctx context.Context) (Stats, error) {
+	c.mu.Lock()
+	size, bytes, opts := int64(c.queue.Len()), c.bytes, c.opts
+	c.mu.Unlock()
+
+	return c.stats("memory", opts, size, bytes), nil
+}
+
+func (c *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *MemoryCache) Close() error {
+	return nil
+}
+
+// Куча заказов: при LFU сначала по числу обращений, затем по давности
+// последнего обращения
+type entryQueue struct {
+	items []*memoryEntry
+	lfu   bool
+}
+
+func (q entryQueue) Len() int { return len(q.items) }
+
+func (q entryQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.lfu && a.count != b.count {
+		return a.count < b.count
+	}
+	return a.seq < b.seq
+}
+
+func (q entryQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *entryQueue) Push(x any) {
+	e := x.(*memoryEntry)
+	e.index = len(q.items)
+	q.items = append(q.items, e)
+}
+
+func (q *entryQueue) Pop() any {
+	last := len(q.items) - 1
+	e := q.items[last]
+	q.items[last] = nil
+	q.items = q.items[:last]
+	e.index = -1
+	return e
+}
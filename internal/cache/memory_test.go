@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	g "orders/internal/generator"
+)
+
+// Заказ с заданным order_uid, остальные поля случайные
+func testOrder(uid string) *g.Order {
+	order := g.MakeRandomOrder(1)[0]
+	order.OrderUID = uid
+	return order
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		// "set a" кладет заказ, "get a" обращается к нему, "wait" ждет
+		// дольше TTL
+		ops     []string
+		present []string
+		evicted []string
+	}{
+		{
+			name:    "lru evicts least recently used",
+			opts:    Options{Policy: PolicyLRU, Capacity: 2},
+			ops:     []string{"set a", "set b", "get a", "set c"},
+			present: []string{"a", "c"},
+			evicted: []string{"b"},
+		},
+		{
+			name:    "lru counts writes as use",
+			opts:    Options{Policy: PolicyLRU, Capacity: 2},
+			ops:     []string{"set a", "set b", "set a", "set c"},
+			present: []string{"a", "c"},
+			evicted: []string{"b"},
+		},
+		{
+			name:    "lfu evicts least frequently used",
+			opts:    Options{Policy: PolicyLFU, Capacity: 2},
+			ops:     []string{"set a", "set b", "get a", "get a", "set c"},
+			present: []string{"a", "c"},
+			evicted: []string{"b"},
+		},
+		{
+			name:    "lfu admits new orders at the lowest count",
+			opts:    Options{Policy: PolicyLFU, Capacity: 2},
+			ops:     []string{"set a", "set b", "get a", "get a", "get b", "get b", "set c", "get b", "set d"},
+			present: []string{"b", "d"},
+			evicted: []string{"a", "c"},
+		},
+		{
+			name:    "lfu breaks ties by age",
+			opts:    Options{Policy: PolicyLFU, Capacity: 2},
+			ops:     []string{"set a", "set b", "set c"},
+			present: []string{"b", "c"},
+			evicted: []string{"a"},
+		},
+		{
+			name:    "ttl expires entries",
+			opts:    Options{Policy: PolicyTTL, Capacity: 10, TTL: 20 * time.Millisecond},
+			ops:     []string{"set a", "wait", "set b"},
+			present: []string{"b"},
+			evicted: []string{"a"},
+		},
+		{
+			name:    "ttl evicts by capacity",
+			opts:    Options{Policy: PolicyTTL, Capacity: 1, TTL: time.Minute},
+			ops:     []string{"set a", "set b"},
+			present: []string{"b"},
+			evicted: []string{"a"},
+		},
+		{
+			name:    "bytes keeps total size under limit",
+			opts:    Options{Policy: PolicyBytes, Capacity: 10},
+			ops:     []string{"set a", "set b", "get a", "set c"},
+			present: []string{"a", "c"},
+			evicted: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			opts := tt.opts
+			opts.Codec = Codec{Format: FormatJSON}
+
+			orders, sizes := make(map[string]*g.Order), make(map[string]int64)
+			for _, uid := range []string{"a", "b", "c", "d"} {
+				orders[uid] = testOrder(uid)
+				data, err := opts.Codec.Encode(orders[uid])
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes[uid] = int64(len(data))
+			}
+			if opts.Policy == PolicyBytes {
+				// Места хватает на a и любой из двух других заказов, но не на три
+				opts.MaxBytes = sizes["a"] + max(sizes["b"], sizes["c"])
+			}
+			cache := NewMemoryCache(opts)
+
+			for _, op := range tt.ops {
+				action, uid, _ := strings.Cut(op, " ")
+				switch action {
+				case "set":
+					if err := cache.Set(ctx, orders[uid]); err != nil {
+						t.Fatal(err)
+					}
+				case "get":
+					if _, err := cache.Get(ctx, uid); err != nil {
+						t.Fatalf("%s: %v", op, err)
+					}
+				case "wait":
+					time.Sleep(2 * opts.TTL)
+				}
+			}
+
+			for _, uid := range tt.present {
+				if _, _, err := cache.Inspect(ctx, uid); err != nil {
+					t.Errorf("order %s: %v, want it cached", uid, err)
+				}
+			}
+			for _, uid := range tt.evicted {
+				if _, _, err := cache.Inspect(ctx, uid); !errors.Is(err, ErrMiss) {
+					t.Errorf("order %s: %v, want it evicted", uid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCacheResize(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(Options{Policy: PolicyLRU, Capacity: 3, Codec: Codec{Format: FormatJSON}})
+	for _, uid := range []string{"a", "b", "c"} {
+		if err := cache.Set(ctx, testOrder(uid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evicted, err := cache.Resize(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 2 {
+		t.Fatalf("evicted %d orders, want 2", evicted)
+	}
+	if _, _, err := cache.Inspect(ctx, "c"); err != nil {
+		t.Fatalf("newest order: %v, want it cached", err)
+	}
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"orders/internal/config"
+)
+
+type Policy string
+
+const (
+	// Вытесняются заказы, к которым дольше всего не обращались
+	PolicyLRU Policy = "lru"
+	// Вытесняются заказы с наименьшим числом обращений. Новый заказ
+	// получает счетчик наименее используемого из оставшихся, иначе он
+	// вытеснялся бы раньше, чем к нему успеют обратиться. При равенстве
+	// счетчиков в памяти вытесняется более старый заказ, а в Redis – с
+	// меньшим ключом: одинаковые score ZSET сортирует лексикографически
+	PolicyLFU Policy = "lfu"
+	// Как LRU, но заказ еще и истекает через TTL после последнего обращения
+	PolicyTTL Policy = "ttl"
+	// Как LRU, но кроме числа заказов ограничен их суммарный размер
+	PolicyBytes Policy = "bytes"
+)
+
+const (
+	defaultCapacity = 200
+	defaultTTL      = 10 * time.Minute
+	defaultMaxBytes = 64 << 20
+)
+
+// Политика вытеснения и ее параметры. Ограничение по числу заказов
+// действует при любой политике
+type Options struct {
+	Policy   Policy
+	Capacity int
+	// Только для PolicyTTL
+	TTL time.Duration
+	// Только для PolicyBytes
+	MaxBytes int64
+	// Формат заказов в кэше, размер для PolicyBytes считается после кодирования
+	Codec Codec
+}
+
+func LoadOptions() (Options, error) {
+	opts := Options{
+		Policy:   Policy(config.GetString("CACHE_POLICY", string(PolicyLRU))),
+		Capacity: config.GetInt("CACHE_CAPACITY", defaultCapacity),
+	}
+	if opts.Capacity <= 0 {
+		return Options{}, fmt.Errorf("CACHE_CAPACITY must be positive, got %d", opts.Capacity)
+	}
+
+	codec, err := LoadCodec()
+	if err != nil {
+		return Options{}, err
+	}
+	opts.Codec = codec
+
+	switch opts.Policy {
+	case PolicyLRU, PolicyLFU:
+	case PolicyTTL:
+		opts.TTL = config.GetDuration("CACHE_TTL", defaultTTL)
+		if opts.TTL <= 0 {
+			return Options{}, fmt.Errorf("CACHE_TTL must be positive, got %v", opts.TTL)
+		}
+	case PolicyBytes:
+		opts.MaxBytes = int64(config.GetInt("CACHE_MAX_BYTES", defaultMaxBytes))
+		if opts.MaxBytes <= 0 {
+			return Options{}, fmt.Errorf("CACHE_MAX_BYTES must be positive, got %d", opts.MaxBytes)
+		}
+	default:
+		return Options{}, fmt.Errorf("unknown CACHE_POLICY %q", opts.Policy)
+	}
+	return opts, nil
+}
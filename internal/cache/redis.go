@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	g "orders/internal/generator"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Кэш на Redis: заказы лежат в ключах {prefix}v<версия>:<order_uid>,
+// а порядок вытеснения – в ZSET {prefix}lru. При политике bytes размеры
+// заказов хранятся в хэше {prefix}lru-sizes, а их сумма – в
+// {prefix}lru-bytes, см. keys.go
+type RedisCache struct {
+	RedisClient redis.UniversalClient
+	opts        Options
+	keys        keyspace
+	counters
+}
+
+const capacityTimeout = time.Second
+
+func NewRedisCache(client redis.UniversalClient, keyPrefix string, opts Options) *RedisCache {
+	keys := newKeyspace(keyPrefix, g.OrderSchemaVersion)
+	return &RedisCache{RedisClient: client, opts: opts, keys: keys}
+}
+
+// Емкость, измененная через админский API, хранится в Redis и общая для
+// всех реплик. Если Redis не ответил, возвращается емкость из настроек
+func (c *RedisCache) Capacity() int {
+	ctx, cancel := context.WithTimeout(context.Background(), capacityTimeout)
+	defer cancel()
+
+	capacity, err := c.capacity(ctx)
+	if err != nil {
+		log.Println("Error reading cache capacity from Redis:", err)
+		return c.opts.Capacity
+	}
+	return capacity
+}
+
+func (c *RedisCache) capacity(ctx context.Context) (int, error) {
+	capacity, err := c.RedisClient.Get(ctx, c.keys.capacity()).Int()
+	if errors.Is(err, redis.Nil) {
+		return c.opts.Capacity, nil
+	}
+	return capacity, err
+}
+
+// Ключи и аргументы, общие для всех скриптов, см. scripts.go
+func (c *RedisCache) scriptKeys(uids ...string) []string {
+	keys := []string{c.keys.lru(), c.keys.sizes(), c.keys.bytes(), c.keys.capacity()}
+	return append(keys, c.keys.orderKeys(uids)...)
+}
+
+func (c *RedisCache) scriptArgs(extra ...any) []any {
+	args := []any{
+		string(c.opts.Policy),
+		c.opts.Capacity,
+		c.opts.TTL.Milliseconds(),
+		c.opts.MaxBytes,
+		time.Now().UnixMilli(),
+	}
+	return append(args, extra...)
+}
+
+// Заказы приходят от самых свежих к старым, поэтому записываются с
+// конца, чтобы самые свежие получили наибольший score
+func (c *RedisCache) Warm(ctx context.Context, latestOrders []*g.Order) (int, error) {
+	reversed := make([]*g.Order, 0, len(latestOrders))
+	for i := len(latestOrders) - 1; i >= 0; i-- {
+		reversed = append(reversed, latestOrders[i])
+	}
+
+	if err := c.Set(ctx, reversed...); err != nil {
+		log.Println("Error filling cache:", err)
+		return 0, err
+	}
+
+	size, err := c.RedisClient.ZCard(ctx, c.keys.lru()).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(size), nil
+}
+
+func (c *RedisCache) Hottest(ctx context.Context, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	keys, err := c.RedisClient.ZRevRange(ctx, c.keys.lru(), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Ключи старой версии схемы пропускаются
+	uids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if uid, ok := c.keys.uid(key); ok {
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// Порядок заказов в батче сохраняется за счет сдвига score на единицу
+func (c *RedisCache) Set(ctx context.Context, orders ...*g.Order) error {
+	var uids []string
+	var data []any
+
+	for _, order := range orders {
+		encoded, err := c.opts.Codec.Encode(order)
+		if err != nil {
+			log.Println("Error marshalling order before adding to cache:", err)
+			continue
+		}
+		uids = append(uids, order.OrderUID)
+		data = append(data, encoded)
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	evicted, err := setScript.Run(ctx, c.RedisClient, c.scriptKeys(uids...), c.scriptArgs(data...)...).Int64()
+	if err != nil {
+		log.Println("Error adding orders to Redis:", err)
+		return err
+	}
+	c.evictions.Add(evicted)
+	return nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, uid string) (*g.Order, error) {
+	data, err := c.get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := c.opts.Codec.Decode(data)
+	if err != nil {
+		log.Println("Error marshalling cached data for", uid)
+		return nil, err
+	}
+	c.hits.Add(1)
+	return order, nil
+}
+
+func (c *RedisCache) GetRendered(ctx context.Context, uid string) (*Rendered, error) {
+	data, err := c.get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := c.opts.Codec.Rendered(data)
+	if err != nil {
+		log.Println("Error marshalling cached data for", uid)
+		return nil, err
+	}
+	c.hits.Add(1)
+	return rendered, nil
+}
+
+// Отмечает обращения к заказам, прочитанным мимо Redis. Заказы, которых
+// в Redis уже нет, пропускаются
+func (c *RedisCache) touch(ctx context.Context, hits map[string]int) error {
+	uids := make([]string, 0, len(hits))
+	counts := make([]any, 0, len(hits))
+	for uid, n := range hits {
+		uids = append(uids, uid)
+		counts = append(counts, n)
+	}
+
+	evicted, err := touchScript.Run(ctx, c.RedisClient, c.scriptKeys(uids...), c.scriptArgs(counts...)...).Int64()
+	if err != nil {
+		return err
+	}
+	c.evictions.Add(evicted)
+	return nil
+}
+
+// Закодированный заказ с отметкой обращения к нему
+func (c *RedisCache) get(ctx context.Context, uid string) ([]byte, error) {
+	data, err := getScript.Run(ctx, c.RedisClient, c.scriptKeys(uid), c.scriptArgs()...).Text()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.misses.Add(1)
+			return nil, ErrMiss
+		}
+		log.Println("Can't find cached data for", uid)
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (c *RedisCache) Remove(ctx context.Context, uid string) error {
+	err := removeScript.Run(ctx, c.RedisClient, c.scriptKeys(uid), c.scriptArgs()...).Err()
+	if err != nil {
+		log.Printf("Error removing order with uid %s from cache: %v\n", uid, err)
+		return err
+	}
+	return nil
+}
+
+func (c *RedisCache) Lock(ctx context.Context, uid string, ttl time.Duration) (func(), bool, error) {
+	key := c.keys.lock(uid)
+	token := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	ok, err := c.RedisClient.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	unlock := func() {
+		err := unlockScript.Run(context.WithoutCancel(ctx), c.RedisClient, []string{key}, token).Err()
+		if err != nil {
+			log.Printf("Error releasing cache lock for %s: %v\n", uid, err)
+		}
+	}
+	return unlock, true, nil
+}
+
+func (c *RedisCache) Stats(ctx context.Context) (Stats, error) {
+	size, err := c.RedisClient.ZCard(ctx, c.keys.lru()).Result()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	opts := c.opts
+	if opts.Capacity, err = c.capacity(ctx); err != nil {
+		return Stats{}, err
+	}
+
+	var bytes int64
+	if c.opts.MaxBytes > 0 {
+		bytes, err = c.RedisClient.Get(ctx, c.keys.bytes()).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return Stats{}, err
+		}
+	}
+	return c.stats("redis", opts, size, bytes), nil
+}
+
+func (c *RedisCache) Entries(ctx context.Context, n int, oldest bool) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	query := redis.ZRangeArgs{Key: c.keys.lru(), Start: 0, Stop: n - 1, Rev: !oldest}
+	members, err := c.RedisClient.ZRangeArgsWithScores(ctx, query).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(members))
+	for _, m := range members {
+		key := m.Member.(string)
+		// Ключи старой версии схемы показываются целиком
+		uid, ok := c.keys.uid(key)
+		if !ok {
+			uid = key
+		}
+		entries = append(entries, Entry{UID: uid, Score: m.Score})
+	}
+	return entries, nil
+}
+
+func (c *RedisCache) Inspect(ctx context.Context, uid string) (*g.Order, Entry, error) {
+	key := c.keys.order(uid)
+
+	pipe := c.RedisClient.Pipeline()
+	get := pipe.Get(ctx, key)
+	score := pipe.ZScore(ctx, c.keys.lru(), key)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, Entry{}, err
+	}
+
+	data, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, Entry{}, ErrMiss
+	}
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	order, err := c.opts.Codec.Decode(data)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	// Ключ без члена ZSET получит score при следующем чтении
+	return order, Entry{UID: uid, Score: score.Val(), Bytes: len(data)}, nil
+}
+
+func (c *RedisCache) Flush(ctx context.Context) (int, error) {
+	flushed, err := flushScript.Run(ctx, c.RedisClient, c.scriptKeys(), c.scriptArgs()...).Int()
+	if err != nil {
+		log.Println("Error flushing cache:", err)
+		return 0, err
+	}
+	return flushed, nil
+}
+
+// Новая емкость хранится в Redis и действует на все реплики
+func (c *RedisCache) Resize(ctx context.Context, capacity int) (int, error) {
+	evicted, err := resizeScript.Run(ctx, c.RedisClient, c.scriptKeys(), c.scriptArgs(capacity)...).Int64()
+	if err != nil {
+		log.Println("Error resizing cache:", err)
+		return 0, err
+	}
+	c.evictions.Add(evicted)
+	return int(evicted), nil
+}
+
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.RedisClient.Ping(ctx).Err()
+}
+
+func (c *RedisCache) Close() error {
+	return c.RedisClient.Close()
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	g "orders/internal/generator"
+)
+
+// Готовый ответ GET /orders/{order_uid}: тело, его gzip-вариант и
+// сильный ETag. При CACHE_ENCODING=response (по умолчанию) хранится
+// в кэше целиком, и попадание в кэш пишется в ответ без декодирования
+// заказа
+type Rendered struct {
+	Body []byte
+	Gzip []byte
+	ETag string
+}
+
+var errBadRendered = errors.New("malformed cached response")
+
+// Тело совпадает с тем, что раньше собирал GetOrderByIdHandler
+func Render(order *g.Order) (*Rendered, error) {
+	body, err := json.MarshalIndent(order, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	return &Rendered{Body: body, Gzip: buf.Bytes(), ETag: etag}, nil
+}
+
+// ETag gzip-варианта отличается, потому что это другие байты
+func (r *Rendered) GzipETag() string {
+	return r.ETag[:len(r.ETag)-1] + `-gz"`
+}
+
+// Значение без префикса формата: длина ETag, ETag, длина тела, тело,
+// gzip. Длины – uvarint
+func (r *Rendered) marshal() []byte {
+	b := make([]byte, 0, 2*binary.MaxVarintLen64+len(r.ETag)+len(r.Body)+len(r.Gzip))
+	b = binary.AppendUvarint(b, uint64(len(r.ETag)))
+	b = append(b, r.ETag...)
+	b = binary.AppendUvarint(b, uint64(len(r.Body)))
+	b = append(b, r.Body...)
+	return append(b, r.Gzip...)
+}
+
+func unmarshalRendered(b []byte) (*Rendered, error) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return nil, errBadRendered
+	}
+	etag, b := string(b[n:n+int(size)]), b[n+int(size):]
+
+	size, n = binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return nil, errBadRendered
+	}
+	b = b[n:]
+	return &Rendered{Body: b[:size:size], Gzip: b[size:], ETag: etag}, nil
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRenderedRoundTrip(t *testing.T) {
+	for _, etag := range []string{`"short"`, `"` + strings.Repeat("x", 300) + `"`} {
+		want := &Rendered{Body: []byte(`{"order_uid":"a"}`), Gzip: []byte{1, 2, 3}, ETag: etag}
+		got, err := unmarshalRendered(want.marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ETag != want.ETag || string(got.Body) != string(want.Body) || string(got.Gzip) != string(want.Gzip) {
+			t.Errorf("ETag of %d bytes: got %+v, want %+v", len(etag), got, want)
+		}
+	}
+}
+
+// Попадание в кэш: готовый ответ против декодирования заказа и
+// json.MarshalIndent, как раньше делал GetOrderByIdHandler
+func BenchmarkCachedHit(b *testing.B) {
+	ctx := context.Background()
+	order := testOrder("bench")
+
+	b.Run("get+marshal", func(b *testing.B) {
+		cache := NewMemoryCache(Options{Policy: PolicyLRU, Capacity: 10, Codec: Codec{Format: FormatJSON}})
+		if err := cache.Set(ctx, order); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			cached, err := cache.Get(ctx, order.OrderUID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := json.MarshalIndent(cached, "", "    "); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("rendered", func(b *testing.B) {
+		cache := NewMemoryCache(Options{Policy: PolicyLRU, Capacity: 10, Codec: Codec{Format: FormatResponse}})
+		if err := cache.Set(ctx, order); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			if _, err := cache.GetRendered(ctx, order.OrderUID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package cache
+
+import "github.com/redis/go-redis/v9"
+
+// Все изменения ключей заказов и ZSET {prefix}lru выполняются Lua-скриптами,
+// поэтому при нескольких репликах сервиса набор ключей и ZSET не расходятся.
+// Все ключи содержат один хэш-тег, поэтому в Redis Cluster скрипты
+// выполняются на одном узле.
+//
+// Общие параметры скриптов:
+// KEYS[1] – ZSET, KEYS[2] – хэш размеров заказов, KEYS[3] – суммарный
+// размер, KEYS[4] – емкость, измененная через админский API, KEYS[5..] –
+// ключи заказов.
+// ARGV[1] – политика, ARGV[2] – емкость из настроек, если KEYS[4] нет,
+// ARGV[3] – TTL в мс (0 – без TTL),
+// ARGV[4] – ограничение по байтам (0 – без ограничения), ARGV[5] – текущее
+// время в мс.
+//
+// Score в ZSET – время последнего обращения, а при LFU – число обращений,
+// у нового заказа – как у наименее используемого из оставшихся
+
+const policyLua = `
+local policy = ARGV[1]
+local capacity = tonumber(redis.call('GET', KEYS[4])) or tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local maxBytes = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local evicted = 0
+
+local function forget(key)
+	redis.call('DEL', key)
+	local size = tonumber(redis.call('HGET', KEYS[2], key))
+	if size then
+		redis.call('HDEL', KEYS[2], key)
+		redis.call('DECRBY', KEYS[3], size)
+	end
+end
+
+local function track(key, size)
+	if maxBytes == 0 then
+		return
+	end
+	local old = tonumber(redis.call('HGET', KEYS[2], key)) or 0
+	redis.call('HSET', KEYS[2], key, size)
+	redis.call('INCRBY', KEYS[3], size - old)
+end
+
+-- limit – сколько заказов оставить, по умолчанию емкость
+local function trim(limit)
+	limit = limit or capacity
+	if ttl > 0 then
+		local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. (now - ttl))
+		for _, key in ipairs(expired) do
+			redis.call('ZREM', KEYS[1], key)
+			forget(key)
+			evicted = evicted + 1
+		end
+	end
+
+	while redis.call('ZCARD', KEYS[1]) > limit or
+		(maxBytes > 0 and (tonumber(redis.call('GET', KEYS[3])) or 0) > maxBytes) do
+		local popped = redis.call('ZPOPMIN', KEYS[1])
+		if #popped == 0 then
+			break
+		end
+		forget(popped[1])
+		evicted = evicted + 1
+	end
+end
+
+local function store(key, data)
+	if ttl > 0 then
+		redis.call('SET', key, data, 'PX', ttl)
+	else
+		redis.call('SET', key, data)
+	end
+	track(key, #data)
+end
+`
+
+// ARGV[6..] – заказы в порядке KEYS[5..]. Запись не считается
+// обращением для LFU: новому заказу место освобождается заранее, и он
+// получает score наименее используемого из оставшихся. Возвращает
+// число вытесненных заказов
+var setScript = redis.NewScript(policyLua + `
+for i = 5, #KEYS do
+	store(KEYS[i], ARGV[i + 1])
+	if policy == 'lfu' then
+		if not redis.call('ZSCORE', KEYS[1], KEYS[i]) then
+			trim(capacity - 1)
+			local lowest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+			redis.call('ZADD', KEYS[1], tonumber(lowest[2]) or 1, KEYS[i])
+		end
+	else
+		redis.call('ZADD', KEYS[1], now + i - 5, KEYS[i])
+	end
+end
+trim()
+return evicted
+`)
+
+// KEYS[5] – ключ заказа. Возвращает заказ и отмечает обращение к нему.
+// Член ZSET без ключа удаляется, а ключ без члена ZSET снова попадает
+// в кэш
+var getScript = redis.NewScript(policyLua + `
+local data = redis.call('GET', KEYS[5])
+if not data then
+	redis.call('ZREM', KEYS[1], KEYS[5])
+	forget(KEYS[5])
+	return false
+end
+
+if policy == 'lfu' then
+	redis.call('ZINCRBY', KEYS[1], 1, KEYS[5])
+else
+	redis.call('ZADD', KEYS[1], now, KEYS[5])
+end
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[5], ttl)
+end
+if maxBytes > 0 and redis.call('HEXISTS', KEYS[2], KEYS[5]) == 0 then
+	track(KEYS[5], #data)
+end
+trim()
+return data
+`)
+
+// ARGV[6..] – число обращений к заказам KEYS[5..] в L1, см. TieredCache.
+// Отмечает обращения так же, как getScript, но не возвращает заказы.
+// Возвращает число вытесненных заказов
+var touchScript = redis.NewScript(policyLua + `
+for i = 5, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		if policy == 'lfu' then
+			redis.call('ZINCRBY', KEYS[1], tonumber(ARGV[i + 1]), KEYS[i])
+		else
+			redis.call('ZADD', KEYS[1], now, KEYS[i])
+		end
+		if ttl > 0 then
+			redis.call('PEXPIRE', KEYS[i], ttl)
+		end
+		if maxBytes > 0 and redis.call('HEXISTS', KEYS[2], KEYS[i]) == 0 then
+			track(KEYS[i], redis.call('STRLEN', KEYS[i]))
+		end
+	else
+		redis.call('ZREM', KEYS[1], KEYS[i])
+		forget(KEYS[i])
+	end
+end
+trim()
+return evicted
+`)
+
+// KEYS[5] – ключ заказа
+var removeScript = redis.NewScript(policyLua + `
+redis.call('ZREM', KEYS[1], KEYS[5])
+forget(KEYS[5])
+return 1
+`)
+
+// ARGV[6] – новая емкость. Сохраняет ее для всех реплик и сразу
+// вытесняет лишнее. Возвращает число вытесненных заказов
+var resizeScript = redis.NewScript(policyLua + `
+capacity = tonumber(ARGV[6])
+redis.call('SET', KEYS[4], capacity)
+trim()
+return evicted
+`)
+
+// Удаляет все заказы из ZSET вместе с их ключами и размерами.
+// Возвращает число удаленных заказов
+var flushScript = redis.NewScript(policyLua + `
+local keys = redis.call('ZRANGE', KEYS[1], 0, -1)
+for _, key in ipairs(keys) do
+	redis.call('DEL', key)
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return #keys
+`)
+
+// KEYS[1] – ключ блокировки, ARGV[1] – токен владельца. Снимает
+// блокировку, только если она все еще наша
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
@@ -0,0 +1,485 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	g "orders/internal/generator"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	resubscribeDelay = time.Second
+	touchInterval    = time.Second
+)
+
+// Двухуровневый кэш: L1 внутри процесса перед Redis. Любая запись или
+// удаление заказа рассылается через pub/sub, и остальные реплики
+// выбрасывают свою копию из L1. Пока подписка не активна, L1 не
+// используется, потому что сообщения об изменениях могут теряться.
+// Попадания в L1 копятся и раз в touchInterval отмечаются в Redis,
+// поэтому политика L2 видит обращения, а TTL заказа продлевается.
+// Заказ живет в L1 не дольше TTL из L2
+type TieredCache struct {
+	*RedisCache
+	local  *localCache
+	origin string
+	pubsub *redis.PubSub
+
+	mu   sync.Mutex
+	hits map[string]int
+	stop chan struct{}
+	done chan struct{}
+}
+
+type invalidation struct {
+	Origin string   `json:"origin"`
+	UIDs   []string `json:"uids,omitempty"`
+	// Кэш очищен целиком
+	All bool `json:"all,omitempty"`
+	// Новая емкость L2 после изменения через админский API
+	Capacity int `json:"capacity,omitempty"`
+}
+
+func NewTieredCache(l2 *RedisCache, capacity int) *TieredCache {
+	c := &TieredCache{
+		RedisCache: l2,
+		local:      newLocalCache(capacity, l2.opts.TTL),
+		origin:     strconv.FormatUint(rand.Uint64(), 36),
+		pubsub:     l2.RedisClient.Subscribe(context.Background(), l2.keys.invalidations()),
+		hits:       make(map[string]int),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go c.listen()
+	go c.touchLoop()
+	return c
+}
+
+func (c *TieredCache) Get(ctx context.Context, uid string) (*g.Order, error) {
+	if order, ok := c.local.get(uid); ok {
+		c.hit(uid)
+		return order, nil
+	}
+
+	// Если заказ поменяли, пока он читался из Redis, в L1 он не попадет
+	version := c.local.version()
+	order, err := c.RedisCache.Get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	c.local.add(order.OrderUID, localItem{order: order}, version)
+	return order, nil
+}
+
+func (c *TieredCache) GetRendered(ctx context.Context, uid string) (*Rendered, error) {
+	if rendered, ok := c.local.getRendered(uid); ok {
+		c.hit(uid)
+		return rendered, nil
+	}
+
+	version := c.local.version()
+	rendered, err := c.RedisCache.GetRendered(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	c.local.add(uid, localItem{rendered: rendered}, version)
+	return rendered, nil
+}
+
+func (c *TieredCache) Set(ctx context.Context, orders ...*g.Order) error {
+	uids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		uids = append(uids, order.OrderUID)
+	}
+
+	c.local.remove(uids...)
+	if err := c.RedisCache.Set(ctx, orders...); err != nil {
+		return err
+	}
+	c.broadcast(ctx, invalidation{UIDs: uids})
+	return nil
+}
+
+func (c *TieredCache) Warm(ctx context.Context, latestOrders []*g.Order) (int, error) {
+	uids := make([]string, 0, len(latestOrders))
+	for _, order := range latestOrders {
+		uids = append(uids, order.OrderUID)
+	}
+
+	c.local.remove(uids...)
+	warmed, err := c.RedisCache.Warm(ctx, latestOrders)
+	if err != nil {
+		return 0, err
+	}
+	c.broadcast(ctx, invalidation{UIDs: uids})
+	return warmed, nil
+}
+
+func (c *TieredCache) Remove(ctx context.Context, uid string) error {
+	c.local.remove(uid)
+	if err := c.RedisCache.Remove(ctx, uid); err != nil {
+		return err
+	}
+	c.broadcast(ctx, invalidation{UIDs: []string{uid}})
+	return nil
+}
+
+func (c *TieredCache) Flush(ctx context.Context) (int, error) {
+	c.local.flush()
+	flushed, err := c.RedisCache.Flush(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.broadcast(ctx, invalidation{All: true})
+	return flushed, nil
+}
+
+// L1 не бывает больше L2, поэтому при уменьшении емкости реплики
+// уменьшают и его
+func (c *TieredCache) Resize(ctx context.Context, capacity int) (int, error) {
+	evicted, err := c.RedisCache.Resize(ctx, capacity)
+	if err != nil {
+		return 0, err
+	}
+	c.local.resize(capacity)
+	c.broadcast(ctx, invalidation{Capacity: capacity})
+	return evicted, nil
+}
+
+func (c *TieredCache) Stats(ctx context.Context) (Stats, error) {
+	stats, err := c.RedisCache.Stats(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+	l1 := c.local.stats()
+	stats.L1 = &l1
+	return stats, nil
+}
+
+func (c *TieredCache) Close() error {
+	close(c.stop)
+	<-c.done
+	if err := c.pubsub.Close(); err != nil {
+		log.Println("Error closing cache invalidation subscription:", err)
+	}
+	return c.RedisCache.Close()
+}
+
+// Ошибка рассылки не откатывает запись: реплики увидят новый заказ,
+// когда он вытеснится из их L1
+func (c *TieredCache) broadcast(ctx context.Context, inv invalidation) {
+	inv.Origin = c.origin
+	msg, err := json.Marshal(inv)
+	if err != nil {
+		log.Println("Error marshalling cache invalidation:", err)
+		return
+	}
+	if err := c.RedisCache.RedisClient.Publish(ctx, c.keys.invalidations(), msg).Err(); err != nil {
+		log.Println("Error publishing cache invalidation:", err)
+	}
+}
+
+func (c *TieredCache) hit(uid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits[uid]++
+}
+
+// Отмечает накопленные попадания в L1 в Redis. Перед закрытием кэша
+// отправляет остаток
+func (c *TieredCache) touchLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(touchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.touch()
+		case <-c.stop:
+			c.touch()
+			return
+		}
+	}
+}
+
+// Ошибка не повторяется: обращения теряются, и заказ может вытесниться
+// из L2 раньше, чем из L1
+func (c *TieredCache) touch() {
+	c.mu.Lock()
+	hits := c.hits
+	if len(hits) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.hits = make(map[string]int)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), touchInterval)
+	defer cancel()
+	if err := c.RedisCache.touch(ctx, hits); err != nil {
+		log.Println("Error marking L1 cache hits in Redis:", err)
+	}
+}
+
+// Читает сообщения об изменениях заказов. После обрыва соединения
+// go-redis подписывается заново, а сообщения за время обрыва потеряны,
+// поэтому L1 очищается и включается только после новой подписки
+func (c *TieredCache) listen() {
+	for {
+		msg, err := c.pubsub.Receive(context.Background())
+		if err != nil {
+			if errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			if c.local.disable() {
+				log.Println("Cache invalidation subscription lost, L1 cache disabled:", err)
+			}
+			time.Sleep(resubscribeDelay)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				c.local.enable()
+				log.Println("Subscribed to cache invalidations, L1 cache enabled")
+			}
+		case *redis.Message:
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Println("Error decoding cache invalidation:", err)
+				continue
+			}
+			switch {
+			case inv.Origin == c.origin:
+			case inv.All:
+				c.local.flush()
+			case inv.Capacity > 0:
+				c.local.resize(inv.Capacity)
+			default:
+				c.local.remove(inv.UIDs...)
+			}
+		}
+	}
+}
+
+// LRU заказов внутри процесса. Заказы хранятся готовыми структурами и
+// отдаются копиями, поэтому чтение не требует декодирования. Рядом
+// хранится готовый ответ API, если его уже запрашивали
+type localCache struct {
+	mu       sync.Mutex
+	capacity int
+	// CACHE_L1_CAPACITY, выше которого capacity не растет
+	limit int
+	// 0 – без срока жизни
+	ttl     time.Duration
+	enabled bool
+	// Растет при каждом удалении, см. TieredCache.Get
+	changes uint64
+	order   *list.List
+	items   map[string]*list.Element
+	counters
+}
+
+func newLocalCache(capacity int, ttl time.Duration) *localCache {
+	return &localCache{
+		capacity: capacity,
+		limit:    capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Заполнено хотя бы одно из полей. Недостающее получается из другого
+// при первом обращении
+type localItem struct {
+	uid      string
+	order    *g.Order
+	rendered *Rendered
+	// Не продлевается при обращениях: заказ мог истечь в L2 раньше, чем
+	// до Redis дошла отметка об обращении
+	expires time.Time
+}
+
+func (l *localCache) get(uid string) (*g.Order, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	item, ok := l.lookup(uid)
+	if !ok {
+		return nil, false
+	}
+	if item.order == nil {
+		order, err := decodeJSON(item.rendered.Body)
+		if err != nil {
+			return nil, false
+		}
+		item.order = order
+	}
+	return cloneOrder(item.order), true
+}
+
+func (l *localCache) getRendered(uid string) (*Rendered, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	item, ok := l.lookup(uid)
+	if !ok {
+		return nil, false
+	}
+	if item.rendered == nil {
+		rendered, err := Render(item.order)
+		if err != nil {
+			return nil, false
+		}
+		item.rendered = rendered
+	}
+	// Байты ответа только читаются, поэтому копия не нужна
+	return item.rendered, true
+}
+
+// Вызывается под мьютексом
+func (l *localCache) lookup(uid string) (*localItem, bool) {
+	e, ok := l.items[uid]
+	if !ok {
+		l.misses.Add(1)
+		return nil, false
+	}
+	if item := e.Value.(*localItem); l.ttl > 0 && time.Now().After(item.expires) {
+		l.order.Remove(e)
+		delete(l.items, uid)
+		l.evictions.Add(1)
+		l.misses.Add(1)
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	l.hits.Add(1)
+	return e.Value.(*localItem), true
+}
+
+func (l *localCache) version() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changes
+}
+
+// Добавляет заказ, только если с момента version ничего не удалялось.
+// Уже сохраненная часть записи сохраняется: с момента version заказ
+// не менялся
+func (l *localCache) add(uid string, item localItem, version uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.enabled || l.changes != version {
+		return
+	}
+
+	item.uid = uid
+	if l.ttl > 0 {
+		item.expires = time.Now().Add(l.ttl)
+	}
+	if item.order != nil {
+		item.order = cloneOrder(item.order)
+	}
+
+	if e, ok := l.items[uid]; ok {
+		old := e.Value.(*localItem)
+		if item.order == nil {
+			item.order = old.order
+		}
+		if item.rendered == nil {
+			item.rendered = old.rendered
+		}
+		e.Value = &item
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[uid] = l.order.PushFront(&item)
+	l.trim()
+}
+
+// Емкость L1 – CACHE_L1_CAPACITY, но не больше емкости L2
+func (l *localCache) resize(l2Capacity int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.capacity = min(l.limit, l2Capacity)
+	l.trim()
+}
+
+// Вызывается под мьютексом
+func (l *localCache) trim() {
+	for l.order.Len() > l.capacity {
+		last := l.order.Back()
+		l.order.Remove(last)
+		delete(l.items, last.Value.(*localItem).uid)
+		l.evictions.Add(1)
+	}
+}
+
+func (l *localCache) remove(uids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.changes++
+	for _, uid := range uids {
+		if e, ok := l.items[uid]; ok {
+			l.order.Remove(e)
+			delete(l.items, uid)
+		}
+	}
+}
+
+func (l *localCache) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.changes++
+	l.order.Init()
+	clear(l.items)
+}
+
+func (l *localCache) enable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = true
+}
+
+// Очищает и выключает L1. Возвращает false, если он уже был выключен
+func (l *localCache) disable() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wasEnabled := l.enabled
+	l.enabled = false
+	l.changes++
+	l.order.Init()
+	clear(l.items)
+	return wasEnabled
+}
+
+func (l *localCache) stats() Stats {
+	l.mu.Lock()
+	size := int64(l.order.Len())
+	l.mu.Unlock()
+
+	return l.counters.stats("local", Options{Policy: PolicyLRU, Capacity: l.capacity}, size, 0)
+}
+
+func cloneOrder(order *g.Order) *g.Order {
+	cp := *order
+	cp.Items = slices.Clone(order.Items)
+	return &cp
+}
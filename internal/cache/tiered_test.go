@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// Заказ не живет в L1 дольше TTL из L2, даже если к нему обращаются
+func TestLocalCacheExpires(t *testing.T) {
+	l := newLocalCache(10, 20*time.Millisecond)
+	l.enable()
+
+	l.add("a", localItem{order: testOrder("a")}, l.version())
+	if _, ok := l.get("a"); !ok {
+		t.Fatal("order is not cached")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.get("a"); ok {
+		t.Fatal("order outlived the L2 TTL")
+	}
+}
+
+func TestLocalCacheResize(t *testing.T) {
+	l := newLocalCache(3, 0)
+	l.enable()
+	for _, uid := range []string{"a", "b", "c"} {
+		l.add(uid, localItem{order: testOrder(uid)}, l.version())
+	}
+
+	l.resize(1)
+	if _, ok := l.get("c"); !ok {
+		t.Fatal("most recent order was evicted")
+	}
+	if _, ok := l.get("a"); ok {
+		t.Fatal("L1 is larger than the resized L2")
+	}
+
+	// Выше CACHE_L1_CAPACITY L1 не растет
+	l.resize(100)
+	if l.capacity != 3 {
+		t.Fatalf("capacity %d, want 3", l.capacity)
+	}
+}
@@ -0,0 +1,225 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"orders/internal/bus"
+	"orders/internal/config"
+	"orders/internal/generator"
+	"orders/internal/messages"
+	repo "orders/internal/repository"
+	"orders/internal/trace"
+)
+
+const defaultBatchWait = 500 * time.Millisecond
+
+// Размер батча и время его ожидания. При размере 1 сообщения
+// обрабатываются по одному, как и раньше
+func BatchSettings() (int, time.Duration) {
+	size := config.GetInt("KAFKA_BATCH_SIZE", 1)
+	if size < 1 {
+		size = 1
+	}
+
+	wait := time.Duration(config.GetInt("KAFKA_BATCH_WAIT_MS", int(defaultBatchWait.Milliseconds()))) * time.Millisecond
+	if wait <= 0 {
+		wait = defaultBatchWait
+	}
+	return size, wait
+}
+
+func StartConsuming(sub bus.Subscriber, repo *repo.Repository, ctl *Controller) {
+	for {
+		epoch := ctl.epoch(sub)
+		m, err := sub.Fetch(ctl.stopping)
+		if err != nil {
+			log.Println("Error reading message:", err)
+			break
+		}
+		if !ctl.proceed(sub, epoch) {
+			continue
+		}
+
+		ctx := messages.MessageContext(context.Background(), m)
+		traceID := trace.FromContext(ctx)
+		log.Printf("[trace %s] New message at topic/partition/offset %v/%v/%v from %s: %s = %s\n",
+			traceID, m.Topic, m.Partition, m.Offset, m.Header(messages.HeaderProducer), string(m.Key), string(m.Value))
+
+		orders, invalid, err := messages.DecodeAndValidate(m)
+		if err != nil {
+			log.Printf("[trace %s] Error decoding orders data: %v\n", traceID, err)
+		}
+		for _, err := range invalid {
+			log.Printf("[trace %s] Skipping order: %v\n", traceID, err)
+		}
+
+		// Заказы сохраняются одной транзакцией, как батч из одного
+		// сообщения: дубликаты при повторной доставке не мешают сохранить
+		// остальные. Пока бд недоступна, сообщение сохраняется снова
+		var saved []*generator.Order
+		if err == nil {
+			err = ctl.retry(sub, epoch, []bus.Message{m}, func() error {
+				ok, rejected, err := repo.SaveBatchToDB(orders, ctx)
+				saved = ok
+				logRejected(rejected)
+				return err
+			})
+			if errors.Is(err, errRewound) {
+				continue
+			}
+			if err != nil {
+				log.Printf("[trace %s] Failed to save orders from message: %v\n", traceID, err)
+			}
+		}
+		if err != nil {
+			ctl.fail(err, m)
+			if err := ctl.deadLetter(sub, epoch, err, m); err != nil {
+				continue
+			}
+		}
+
+		if err := ctl.commit(ctx, sub, epoch, m); err != nil {
+			if errors.Is(err, errRewound) {
+				log.Println("Subscription was rewound, not committing messages read before it")
+				continue
+			}
+			log.Fatalln("Error committing message:", err)
+		}
+		log.Printf("[trace %s] Committed message at topic/partition/offset %v/%v/%v\n",
+			traceID, m.Topic, m.Partition, m.Offset)
+		if err == nil {
+			ctl.done(sub, m)
+		}
+
+		if err := repo.Cache.Set(ctx, saved...); err != nil {
+			log.Printf("[trace %s] Error updating cache: %v\n", traceID, err)
+		}
+	}
+}
+
+func StartBatchConsuming(sub bus.Subscriber, repo *repo.Repository, ctl *Controller, batchSize int, batchWait time.Duration) {
+	ctx := context.Background()
+	log.Printf("Consuming in batch mode: up to %d messages or %v per batch\n", batchSize, batchWait)
+
+	for {
+		epoch := ctl.epoch(sub)
+		batch, err := fetchBatch(ctl.stopping, sub, batchSize, batchWait)
+		if err != nil {
+			log.Println("Error reading message:", err)
+			break
+		}
+		if !ctl.proceed(sub, epoch) {
+			continue
+		}
+
+		orders, err := decodeBatch(sub, ctl, epoch, batch)
+		if err != nil {
+			continue
+		}
+
+		var saved []*generator.Order
+		err = ctl.retry(sub, epoch, batch, func() error {
+			ok, rejected, err := repo.SaveBatchToDB(orders, ctx)
+			saved = ok
+			logRejected(rejected)
+			return err
+		})
+		if errors.Is(err, errRewound) {
+			continue
+		}
+		if err != nil {
+			// Ошибка не в отдельных заказах и не в доступности бд, повторять
+			// батч бесполезно, поэтому он уходит в DLQ
+			log.Printf("Failed to save batch of %d messages: %v\n", len(batch), err)
+			ctl.fail(err, batch...)
+			if err := ctl.deadLetter(sub, epoch, err, batch...); err != nil {
+				continue
+			}
+		}
+
+		if err := ctl.commit(ctx, sub, epoch, batch...); err != nil {
+			if errors.Is(err, errRewound) {
+				log.Println("Subscription was rewound, not committing messages read before it")
+				continue
+			}
+			log.Fatalln("Error committing messages:", err)
+		}
+		last := batch[len(batch)-1]
+		log.Printf("Committed %d messages (%d orders saved) up to topic/partition/offset %v/%v/%v\n",
+			len(batch), len(saved), last.Topic, last.Partition, last.Offset)
+		if err == nil {
+			ctl.done(sub, batch...)
+		}
+
+		if err := repo.Cache.Set(ctx, saved...); err != nil {
+			log.Println("Error updating cache with batch:", err)
+		}
+	}
+}
+
+// Дубликаты уже сохранены раньше, например при повторной доставке
+// сообщения, остальные заказы не сохранятся никогда
+func logRejected(rejected []repo.Rejected) {
+	for _, r := range rejected {
+		if repo.IsDuplicate(r.Err) {
+			log.Printf("Order %s is already saved, skipping\n", r.Order.OrderUID)
+			continue
+		}
+		log.Printf("Skipping order %s that can't be saved: %v\n", r.Order.OrderUID, r.Err)
+	}
+}
+
+// Декодирует заказы из сообщений батча. Сообщения, которые не
+// декодируются, уходят в DLQ: при повторе ошибка будет той же.
+// Возвращает errRewound, если подписку перемотали
+func decodeBatch(sub bus.Subscriber, ctl *Controller, epoch uint64, batch []bus.Message) ([]*generator.Order, error) {
+	var orders []*generator.Order
+	for _, m := range batch {
+		traceID := m.Header(messages.HeaderTraceID)
+		messageOrders, invalid, err := messages.DecodeAndValidate(m)
+		if err != nil {
+			log.Printf("[trace %s] Error decoding orders data at topic/partition/offset %v/%v/%v: %v\n",
+				traceID, m.Topic, m.Partition, m.Offset, err)
+			ctl.fail(err, m)
+			if err := ctl.deadLetter(sub, epoch, err, m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, err := range invalid {
+			log.Printf("[trace %s] Skipping order: %v\n", traceID, err)
+		}
+		log.Printf("[trace %s] Batched message at topic/partition/offset %v/%v/%v with %d orders\n",
+			traceID, m.Topic, m.Partition, m.Offset, len(messageOrders))
+		orders = append(orders, messageOrders...)
+	}
+	return orders, nil
+}
+
+// Ждет первое сообщение, пока не отменят ctx, после чего добирает
+// батч до batchSize, но не дольше batchWait
+func fetchBatch(ctx context.Context, sub bus.Subscriber, batchSize int, batchWait time.Duration) ([]bus.Message, error) {
+	first, err := sub.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []bus.Message{first}
+
+	ctx, cancel := context.WithTimeout(ctx, batchWait)
+	defer cancel()
+
+	for len(batch) < batchSize {
+		m, err := sub.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
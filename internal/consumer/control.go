@@ -0,0 +1,407 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"orders/internal/breaker"
+	"orders/internal/bus"
+	"orders/internal/config"
+	"orders/internal/messages"
+	"orders/internal/repository"
+)
+
+const (
+	lagTimeout = 5 * time.Second
+	// Пауза перед повторным сохранением, пока breaker еще не разомкнулся
+	retryDelay = time.Second
+)
+
+// Подписку перемотали или консьюмер остановили, пока сообщения ждали
+// повторного сохранения. Такие сообщения не коммитятся
+var errRewound = errors.New("subscription was rewound")
+
+// Состояние консьюмеров для админских эндпоинтов: счетчики, последняя
+// ошибка, пауза и перемотка подписок без перезапуска сервиса
+type Controller struct {
+	mu            sync.Mutex
+	paused        bool
+	resumed       chan struct{}
+	processed     int64
+	failed        int64
+	lastError     string
+	lastErrorAt   time.Time
+	subscriptions map[bus.Subscriber]*subscription
+	order         []bus.Subscriber
+	// Перемотка не пересекается с коммитами: коммит сообщения,
+	// полученного до перемотки, затер бы выставленные ей оффсеты
+	seeking sync.RWMutex
+	breaker *breaker.Breaker
+	// nil, если DLQ не настроен
+	deadLetters bus.Publisher
+	// Отменяется в Stop: циклы чтения перестают брать новые сообщения
+	stopping context.Context
+	stop     context.CancelFunc
+	loops    sync.WaitGroup
+}
+
+type subscription struct {
+	topic string
+	// -1 для подписки в составе consumer group
+	partition int
+	// Оффсет последнего обработанного сообщения по партициям
+	offsets map[int]int64
+	// Растет при каждой перемотке, чтобы цикл чтения выбросил сообщение,
+	// полученное до нее
+	epoch uint64
+}
+
+type Status struct {
+	Paused        bool                 `json:"paused"`
+	Storage       breaker.State        `json:"storage,omitempty"`
+	Processed     int64                `json:"processed"`
+	Failed        int64                `json:"failed"`
+	LastError     string               `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time           `json:"last_error_at,omitempty"`
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+}
+
+type SubscriptionStatus struct {
+	Topic     string        `json:"topic"`
+	Partition *int          `json:"partition,omitempty"`
+	Offsets   map[int]int64 `json:"offsets"`
+	Lag       int64         `json:"lag"`
+	LagError  string        `json:"lag_error,omitempty"`
+}
+
+// Настройки автоматической паузы при недоступности хранилищ
+func BreakerSettings() (breaker.Settings, error) {
+	s := breaker.Settings{
+		Threshold:  max(1, config.GetInt("STORAGE_BREAKER_THRESHOLD", 5)),
+		MinBackoff: config.GetDuration("STORAGE_BREAKER_MIN_BACKOFF", time.Second),
+		MaxBackoff: config.GetDuration("STORAGE_BREAKER_MAX_BACKOFF", time.Minute),
+	}
+	return s, s.Validate("STORAGE_BREAKER")
+}
+
+// С breaker'ом консьюмеры сами останавливаются, когда бд недоступна,
+// и продолжают после успешной проверки здоровья. Сообщения, которые
+// обработать не получится, уходят в deadLetters, если он не nil
+func NewController(b *breaker.Breaker, deadLetters bus.Publisher) *Controller {
+	stopping, stop := context.WithCancel(context.Background())
+	return &Controller{
+		resumed:       make(chan struct{}),
+		subscriptions: make(map[bus.Subscriber]*subscription),
+		breaker:       b,
+		deadLetters:   deadLetters,
+		stopping:      stopping,
+		stop:          stop,
+	}
+}
+
+// Запускает цикл чтения подписки. Stop ждет завершения всех циклов
+func (c *Controller) Go(loop func()) {
+	c.loops.Add(1)
+	go func() {
+		defer c.loops.Done()
+		loop()
+	}()
+}
+
+// Останавливает циклы чтения и ждет их завершения. Уже сохраняемые
+// сообщения дообрабатываются и коммитятся, а ждущие повтора или
+// восстановления бд остаются в топике. Подписки и бд должны быть
+// открыты до возврата из Stop
+func (c *Controller) Stop() {
+	c.stop()
+	c.loops.Wait()
+}
+
+// Регистрирует подписку. Для подписки в составе группы partition равен -1
+func (c *Controller) Register(topic string, partition int, sub bus.Subscriber) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscriptions[sub] = &subscription{topic: topic, partition: partition, offsets: make(map[int]int64)}
+	c.order = append(c.order, sub)
+}
+
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+		log.Println("Consumer paused")
+	}
+}
+
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+		log.Println("Consumer resumed")
+	}
+}
+
+// Перематывает подписки топика на оффсет. Для партиционных подписок
+// перематывается только указанная партиция
+func (c *Controller) Seek(ctx context.Context, topic string, partition int, offset int64) error {
+	subs := c.lookup(topic, partition)
+	if len(subs) == 0 {
+		return fmt.Errorf("no subscription for topic %s partition %d", topic, partition)
+	}
+
+	c.seeking.Lock()
+	defer c.seeking.Unlock()
+	for _, sub := range subs {
+		c.bumpEpoch(sub)
+		if err := sub.Seek(ctx, partition, offset); err != nil {
+			return err
+		}
+	}
+	log.Printf("Consumer moved to offset %d on topic/partition %s/%d\n", offset, topic, partition)
+	return nil
+}
+
+// Перематывает все подписки топика на первое сообщение не раньше t
+func (c *Controller) SeekTime(ctx context.Context, topic string, t time.Time) error {
+	subs := c.lookup(topic, -1)
+	if len(subs) == 0 {
+		return fmt.Errorf("no subscription for topic %s", topic)
+	}
+
+	c.seeking.Lock()
+	defer c.seeking.Unlock()
+	for _, sub := range subs {
+		c.bumpEpoch(sub)
+		if err := sub.SeekTime(ctx, t); err != nil {
+			return err
+		}
+	}
+	log.Printf("Consumer moved to %s on topic %s\n", t.Format(time.RFC3339), topic)
+	return nil
+}
+
+func (c *Controller) Status(ctx context.Context) Status {
+	c.mu.Lock()
+	status := Status{
+		Paused:    c.paused,
+		Processed: c.processed,
+		Failed:    c.failed,
+		LastError: c.lastError,
+	}
+	if c.breaker != nil {
+		status.Storage = c.breaker.State()
+	}
+	if !c.lastErrorAt.IsZero() {
+		at := c.lastErrorAt
+		status.LastErrorAt = &at
+	}
+
+	subs := append([]bus.Subscriber(nil), c.order...)
+	for _, sub := range subs {
+		s := c.subscriptions[sub]
+		st := SubscriptionStatus{Topic: s.topic, Offsets: make(map[int]int64, len(s.offsets))}
+		if s.partition >= 0 {
+			partition := s.partition
+			st.Partition = &partition
+		}
+		for p, offset := range s.offsets {
+			st.Offsets[p] = offset
+		}
+		status.Subscriptions = append(status.Subscriptions, st)
+	}
+	c.mu.Unlock()
+
+	// Отставание запрашивается у брокера, поэтому без мьютекса
+	ctx, cancel := context.WithTimeout(ctx, lagTimeout)
+	defer cancel()
+	for i, sub := range subs {
+		lag, err := sub.Lag(ctx)
+		if err != nil {
+			status.Subscriptions[i].LagError = err.Error()
+			continue
+		}
+		status.Subscriptions[i].Lag = lag
+	}
+	return status
+}
+
+// Подписки топика; при partition >= 0 партиционные подписки
+// отбираются по номеру партиции, групповые подходят всегда
+func (c *Controller) lookup(topic string, partition int) []bus.Subscriber {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var subs []bus.Subscriber
+	for _, sub := range c.order {
+		s := c.subscriptions[sub]
+		if s.topic != topic {
+			continue
+		}
+		if partition >= 0 && s.partition >= 0 && s.partition != partition {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+func (c *Controller) bumpEpoch(sub bus.Subscriber) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[sub].epoch++
+}
+
+func (c *Controller) epoch(sub bus.Subscriber) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epochLocked(sub)
+}
+
+// Ждет снятия паузы и восстановления хранилищ перед обработкой
+// полученных сообщений. Возвращает false, если подписку перемотали
+// после начала чтения или консьюмер остановили: такие сообщения
+// обрабатывать не нужно
+func (c *Controller) proceed(sub bus.Subscriber, epoch uint64) bool {
+	for {
+		if c.breaker != nil {
+			select {
+			case <-c.breaker.Ready():
+			case <-c.stopping.Done():
+				return false
+			}
+		}
+
+		c.mu.Lock()
+		if !c.paused {
+			ok := c.epochLocked(sub) == epoch
+			c.mu.Unlock()
+			return ok
+		}
+		wait := c.resumed
+		c.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-c.stopping.Done():
+			return false
+		}
+	}
+}
+
+// Пауза перед повтором, которую прерывает Stop
+func (c *Controller) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-c.stopping.Done():
+	}
+}
+
+// Повторяет save, пока бд недоступна: ошибка засчитывается breaker'у,
+// и после паузы и его восстановления сообщения сохраняются снова, а не
+// пропускаются. Возвращает nil после успешного сохранения, ошибку,
+// которую повторять бесполезно, или errRewound, если подписку перемотали
+// или консьюмер остановили
+func (c *Controller) retry(sub bus.Subscriber, epoch uint64, msgs []bus.Message, save func() error) error {
+	for {
+		err := save()
+		if err == nil || !repository.IsStorageFailure(err) {
+			return err
+		}
+
+		log.Printf("Storage is unavailable, retrying %d messages: %v\n", len(msgs), err)
+		c.fail(err, msgs...)
+		c.sleep(retryDelay)
+		if !c.proceed(sub, epoch) {
+			return errRewound
+		}
+	}
+}
+
+// Отправляет сообщения, которые не обработаются и при повторе, в DLQ,
+// после чего их можно коммитить. Пока DLQ недоступен, отправка
+// повторяется, чтобы сообщения не потерялись. Возвращает errRewound,
+// если подписку перемотали
+func (c *Controller) deadLetter(sub bus.Subscriber, epoch uint64, reason error, msgs ...bus.Message) error {
+	if c.deadLetters == nil {
+		log.Printf("Skipping %d messages, dead letter topic is not configured: %v\n", len(msgs), reason)
+		return nil
+	}
+
+	letters := make([]bus.Message, 0, len(msgs))
+	for _, m := range msgs {
+		letters = append(letters, messages.DeadLetter(m, reason))
+	}
+
+	for {
+		err := c.deadLetters.Publish(context.Background(), letters...)
+		if err == nil {
+			log.Printf("Sent %d messages to dead letter topic: %v\n", len(msgs), reason)
+			return nil
+		}
+
+		log.Printf("Error sending %d messages to dead letter topic, retrying: %v\n", len(msgs), err)
+		c.sleep(retryDelay)
+		if !c.proceed(sub, epoch) {
+			return errRewound
+		}
+	}
+}
+
+// Коммитит сообщения, полученные в эпоху epoch. Если подписку с тех
+// пор перемотали, коммит пропускается и возвращается errRewound
+func (c *Controller) commit(ctx context.Context, sub bus.Subscriber, epoch uint64, msgs ...bus.Message) error {
+	c.seeking.RLock()
+	defer c.seeking.RUnlock()
+
+	if c.epoch(sub) != epoch {
+		return errRewound
+	}
+	return sub.Commit(ctx, msgs...)
+}
+
+func (c *Controller) epochLocked(sub bus.Subscriber) uint64 {
+	if s, ok := c.subscriptions[sub]; ok {
+		return s.epoch
+	}
+	return 0
+}
+
+func (c *Controller) done(sub bus.Subscriber, msgs ...bus.Message) {
+	if c.breaker != nil {
+		c.breaker.Success()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.processed += int64(len(msgs))
+	if s, ok := c.subscriptions[sub]; ok {
+		for _, m := range msgs {
+			s.offsets[m.Partition] = m.Offset
+		}
+	}
+}
+
+func (c *Controller) fail(err error, msgs ...bus.Message) {
+	if c.breaker != nil && repository.IsStorageFailure(err) {
+		c.breaker.Failure(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failed += int64(len(msgs))
+	c.lastError = err.Error()
+	c.lastErrorAt = time.Now()
+}
@@ -0,0 +1,105 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"orders/internal/bus"
+	db "orders/internal/database"
+	"orders/internal/generator"
+	"orders/internal/repository"
+)
+
+// Подписывается на каждую партицию без consumer group. Оффсеты
+// хранятся в таблице consumer_offsets и пишутся в одной транзакции с
+// заказами, поэтому каждое сообщение попадает в бд ровно один раз
+func StartConsumingWithDBOffsets(b bus.Bus, topic, groupID string, repo *repository.Repository, ctl *Controller, batchSize int, batchWait time.Duration) (map[int]bus.Subscriber, error) {
+	ctx := context.Background()
+
+	stored, err := repo.GetConsumerOffsets(ctx, groupID, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers, err := b.SubscribePartitions(ctx, topic, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	for partition, sub := range subscribers {
+		log.Printf("Partition %d: resuming from offset %d stored in database\n", partition, stored[partition])
+		ctl.Register(topic, partition, sub)
+		ctl.Go(func() { consumePartition(sub, topic, groupID, repo, ctl, partition, batchSize, batchWait) })
+	}
+	return subscribers, nil
+}
+
+func consumePartition(sub bus.Subscriber, topic, groupID string, repo *repository.Repository, ctl *Controller, partition, batchSize int, batchWait time.Duration) {
+	ctx := context.Background()
+
+	for {
+		epoch := ctl.epoch(sub)
+		batch, err := fetchBatch(ctl.stopping, sub, batchSize, batchWait)
+		if err != nil {
+			log.Printf("Partition %d: error reading message: %v\n", partition, err)
+			break
+		}
+		if !ctl.proceed(sub, epoch) {
+			continue
+		}
+
+		orders, err := decodeBatch(sub, ctl, epoch, batch)
+		if err != nil {
+			continue
+		}
+
+		last := batch[len(batch)-1]
+		offset := db.SaveConsumerOffsetParams{
+			GroupID:    groupID,
+			Topic:      topic,
+			Partition:  int32(partition),
+			NextOffset: last.Offset + 1,
+		}
+
+		// Пока бд недоступна, батч сохраняется снова с тем же оффсетом
+		var saved []*generator.Order
+		err = ctl.retry(sub, epoch, batch, func() error {
+			ok, rejected, err := repo.SaveBatchToDB(orders, ctx, offset)
+			saved = ok
+			logRejected(rejected)
+			return err
+		})
+		if errors.Is(err, errRewound) {
+			continue
+		}
+		if err != nil {
+			// Повторять батч бесполезно: он уходит в DLQ, а оффсет
+			// сохраняется без заказов, чтобы партиция не встала
+			log.Printf("Partition %d: failed to save batch of %d messages: %v\n", partition, len(batch), err)
+			ctl.fail(err, batch...)
+			if err := ctl.deadLetter(sub, epoch, err, batch...); err != nil {
+				continue
+			}
+			err := ctl.retry(sub, epoch, batch, func() error {
+				_, _, err := repo.SaveBatchToDB(nil, ctx, offset)
+				return err
+			})
+			if errors.Is(err, errRewound) {
+				continue
+			}
+			if err != nil {
+				log.Fatalln("Error saving consumer offset:", err)
+			}
+			continue
+		}
+		log.Printf("Stored %d messages (%d orders) up to topic/partition/offset %v/%v/%v in database\n",
+			len(batch), len(saved), last.Topic, last.Partition, last.Offset)
+		ctl.done(sub, batch...)
+
+		if err := repo.Cache.Set(ctx, saved...); err != nil {
+			log.Println("Error updating cache with batch:", err)
+		}
+	}
+}
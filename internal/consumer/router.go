@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"orders/internal/bus"
+	"orders/internal/messages"
+	"orders/internal/repository"
+	"orders/internal/trace"
+)
+
+type Handler func(ctx context.Context, m bus.Message) error
+
+// Направляет сообщения в обработчик по типу события
+type Router struct {
+	handlers map[string]Handler
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]Handler)}
+}
+
+func (rt *Router) Handle(eventType string, h Handler) {
+	rt.handlers[eventType] = h
+}
+
+func (rt *Router) Dispatch(ctx context.Context, m bus.Message) error {
+	eventType := messages.EventType(m)
+
+	h, ok := rt.handlers[eventType]
+	if !ok {
+		return fmt.Errorf("no handler registered for event %q", eventType)
+	}
+	return h(ctx, m)
+}
+
+// Регистрирует обработчики репозитория для всех известных событий
+func NewRepositoryRouter(repo *repository.Repository) *Router {
+	rt := NewRouter()
+
+	rt.Handle(messages.EventOrderCreated, func(ctx context.Context, m bus.Message) error {
+		orders, invalid, err := messages.DecodeAndValidate(m)
+		if err != nil {
+			return err
+		}
+		for _, err := range invalid {
+			log.Printf("[trace %s] Skipping order: %v\n", trace.FromContext(ctx), err)
+		}
+
+		// Одной транзакцией, как у основного консьюмера: повтор после
+		// обрыва не упрется в дубликат частично вставленного заказа
+		saved, rejected, err := repo.SaveBatchToDB(orders, ctx)
+		if err != nil {
+			return err
+		}
+		logRejected(rejected)
+
+		if err := repo.Cache.Set(ctx, saved...); err != nil {
+			log.Printf("[trace %s] Error updating cache: %v\n", trace.FromContext(ctx), err)
+		}
+		return nil
+	})
+
+	rt.Handle(messages.EventOrderStatusChanged, func(ctx context.Context, m bus.Message) error {
+		var event messages.OrderStatusChanged
+		if err := messages.DecodeEvent(m, &event); err != nil {
+			return err
+		}
+		if event.OrderUID == "" || event.Status == "" {
+			return fmt.Errorf("order_uid and status are required")
+		}
+		return repo.UpdateOrderStatus(ctx, event.OrderUID, event.Status)
+	})
+
+	rt.Handle(messages.EventOrderCancelled, func(ctx context.Context, m bus.Message) error {
+		var event messages.OrderCancelled
+		if err := messages.DecodeEvent(m, &event); err != nil {
+			return err
+		}
+		if event.OrderUID == "" {
+			return fmt.Errorf("order_uid is required")
+		}
+		return repo.CancelOrder(ctx, event.OrderUID)
+	})
+
+	rt.Handle(messages.EventDeliveryUpdated, func(ctx context.Context, m bus.Message) error {
+		var event messages.DeliveryUpdated
+		if err := messages.DecodeEvent(m, &event); err != nil {
+			return err
+		}
+		if event.OrderUID == "" {
+			return fmt.Errorf("order_uid is required")
+		}
+		return repo.UpdateDelivery(ctx, event.OrderUID, event.Delivery)
+	})
+
+	return rt
+}
+
+func StartRouting(sub bus.Subscriber, router *Router, ctl *Controller) {
+	for {
+		epoch := ctl.epoch(sub)
+		m, err := sub.Fetch(ctl.stopping)
+		if err != nil {
+			log.Println("Error reading message:", err)
+			break
+		}
+		if !ctl.proceed(sub, epoch) {
+			continue
+		}
+
+		ctx := messages.MessageContext(context.Background(), m)
+		traceID := trace.FromContext(ctx)
+		eventType := messages.EventType(m)
+		log.Printf("[trace %s] New %s event at topic/partition/offset %v/%v/%v\n",
+			traceID, eventType, m.Topic, m.Partition, m.Offset)
+
+		// Пока бд недоступна, событие обрабатывается повторно. Остальные
+		// ошибки не исправятся сами, поэтому событие уходит в DLQ
+		err = ctl.retry(sub, epoch, []bus.Message{m}, func() error { return router.Dispatch(ctx, m) })
+		if errors.Is(err, errRewound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[trace %s] Failed to handle %s event: %v\n", traceID, eventType, err)
+			ctl.fail(err, m)
+			if err := ctl.deadLetter(sub, epoch, err, m); err != nil {
+				continue
+			}
+		}
+
+		if err := ctl.commit(ctx, sub, epoch, m); err != nil {
+			if errors.Is(err, errRewound) {
+				log.Println("Subscription was rewound, not committing messages read before it")
+				continue
+			}
+			log.Fatalln("Error committing message:", err)
+		}
+		log.Printf("[trace %s] Committed %s event at topic/partition/offset %v/%v/%v\n",
+			traceID, eventType, m.Topic, m.Partition, m.Offset)
+		if err == nil {
+			ctl.done(sub, m)
+		}
+	}
+}
@@ -104,3 +104,37 @@ func (q *Queries) GetSpecificDelivery(ctx context.Context, orderUid string) (Del
 	)
 	return i, err
 }
+
+const updateDelivery = `-- name: UpdateDelivery :execrows
+UPDATE delivery
+SET name = $2, phone = $3, zip = $4, city = $5, address = $6, region = $7, email = $8
+WHERE order_uid = $1
+`
+
+type UpdateDeliveryParams struct {
+	OrderUid string
+	Name     string
+	Phone    string
+	Zip      string
+	City     string
+	Address  string
+	Region   string
+	Email    string
+}
+
+func (q *Queries) UpdateDelivery(ctx context.Context, arg UpdateDeliveryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateDelivery,
+		arg.OrderUid,
+		arg.Name,
+		arg.Phone,
+		arg.Zip,
+		arg.City,
+		arg.Address,
+		arg.Region,
+		arg.Email,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
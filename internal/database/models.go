@@ -9,6 +9,14 @@ import (
 	"time"
 )
 
+type ConsumerOffset struct {
+	GroupID    string
+	Topic      string
+	Partition  int32
+	NextOffset int64
+	UpdatedAt  time.Time
+}
+
 type Delivery struct {
 	OrderUid string
 	Name     string
@@ -48,6 +56,7 @@ type Order struct {
 	SmID              int32
 	DateCreated       time.Time
 	OofShard          string
+	Status            string
 }
 
 type Payment struct {
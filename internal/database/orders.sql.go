@@ -9,6 +9,8 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 const createOrder = `-- name: CreateOrder :exec
@@ -23,10 +25,11 @@ INSERT INTO orders (
     shardkey,
     sm_id,
     date_created,
-    oof_shard
+    oof_shard,
+    status
 )
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-RETURNING order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, status
 `
 
 type CreateOrderParams struct {
@@ -41,6 +44,7 @@ type CreateOrderParams struct {
 	SmID              int32
 	DateCreated       time.Time
 	OofShard          string
+	Status            string
 }
 
 func (q *Queries) CreateOrder(ctx context.Context, arg CreateOrderParams) error {
@@ -56,10 +60,46 @@ func (q *Queries) CreateOrder(ctx context.Context, arg CreateOrderParams) error
 		arg.SmID,
 		arg.DateCreated,
 		arg.OofShard,
+		arg.Status,
 	)
 	return err
 }
 
+const getCustomerOrders = `-- name: GetCustomerOrders :many
+SELECT order_uid FROM orders
+WHERE customer_id = ANY($1::varchar[])
+ORDER BY date_created DESC
+LIMIT $2
+`
+
+type GetCustomerOrdersParams struct {
+	CustomerIds []string
+	RowLimit    int32
+}
+
+func (q *Queries) GetCustomerOrders(ctx context.Context, arg GetCustomerOrdersParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getCustomerOrders, pq.Array(arg.CustomerIds), arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var order_uid string
+		if err := rows.Scan(&order_uid); err != nil {
+			return nil, err
+		}
+		items = append(items, order_uid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getLatestOrders = `-- name: GetLatestOrders :many
 SELECT order_uid FROM orders ORDER BY date_created DESC LIMIT $1
 `
@@ -88,7 +128,7 @@ func (q *Queries) GetLatestOrders(ctx context.Context, limit int32) ([]string, e
 }
 
 const getOrders = `-- name: GetOrders :many
-SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard FROM orders
+SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, status FROM orders
 `
 
 func (q *Queries) GetOrders(ctx context.Context) ([]Order, error) {
@@ -112,6 +152,7 @@ func (q *Queries) GetOrders(ctx context.Context) ([]Order, error) {
 			&i.SmID,
 			&i.DateCreated,
 			&i.OofShard,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -127,7 +168,7 @@ func (q *Queries) GetOrders(ctx context.Context) ([]Order, error) {
 }
 
 const getSpecificOrder = `-- name: GetSpecificOrder :one
-SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard FROM orders WHERE order_uid = $1
+SELECT order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, status FROM orders WHERE order_uid = $1
 `
 
 func (q *Queries) GetSpecificOrder(ctx context.Context, orderUid string) (Order, error) {
@@ -145,6 +186,24 @@ func (q *Queries) GetSpecificOrder(ctx context.Context, orderUid string) (Order,
 		&i.SmID,
 		&i.DateCreated,
 		&i.OofShard,
+		&i.Status,
 	)
 	return i, err
 }
+
+const updateOrderStatus = `-- name: UpdateOrderStatus :execrows
+UPDATE orders SET status = $2 WHERE order_uid = $1
+`
+
+type UpdateOrderStatusParams struct {
+	OrderUid string
+	Status   string
+}
+
+func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateOrderStatus, arg.OrderUid, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -78,6 +78,7 @@ func MakeRandomOrder(amount int) []*Order {
 			SmID:              gf.Number(10, 199),
 			DateCreated:       time.Now(),
 			OofShard:          gf.RandomString([]string{"1", "2", "3", "4", "5", "6", "7"}),
+			OrderStatus:       "created",
 		})
 	}
 
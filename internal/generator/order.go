@@ -2,6 +2,11 @@ package generator
 
 import "time"
 
+// Версия структуры Order в кэше. Увеличивайте ее при любом изменении
+// полей: заказы, закэшированные в старом виде, станут промахами и
+// перечитаются из бд
+const OrderSchemaVersion = 1
+
 type Order struct {
 	OrderUID          string    `json:"order_uid" db:"order_uid"`
 	TrackNumber       string    `json:"track_number" db:"track_number"`
@@ -17,4 +22,5 @@ type Order struct {
 	SmID              int       `json:"sm_id" db:"sm_id"`
 	DateCreated       time.Time `json:"date_created" db:"date_created"`
 	OofShard          string    `json:"status" db:"status"`
+	OrderStatus       string    `json:"order_status" db:"order_status"`
 }
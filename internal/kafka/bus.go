@@ -0,0 +1,245 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"orders/internal/bus"
+	"orders/internal/trace"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Реализация bus.Bus поверх kafka-go
+type Bus struct {
+	cfg *Config
+}
+
+func NewBus(cfg *Config) *Bus {
+	return &Bus{cfg: cfg}
+}
+
+func (b *Bus) EnsureTopics(ctx context.Context, topics ...string) error {
+	return provisionTopics(ctx, b.cfg, topics...)
+}
+
+func (b *Bus) Publisher(topic string) bus.Publisher {
+	w := &kafka.Writer{
+		Addr:      kafka.TCP(b.cfg.Brokers...),
+		Topic:     topic,
+		Balancer:  &kafka.LeastBytes{},
+		Transport: b.cfg.Transport(),
+	}
+	return &publisher{w: w}
+}
+
+func (b *Bus) Subscribe(topic, groupID string) (bus.Subscriber, error) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.cfg.Brokers,
+		Topic:   topic,
+		GroupID: groupID,
+		Dialer:  b.cfg.Dialer(),
+	})
+	return &subscriber{cfg: b.cfg, r: r}, nil
+}
+
+func (b *Bus) SubscribePartitions(ctx context.Context, topic string, offsets map[int]int64) (map[int]bus.Subscriber, error) {
+	conn, err := dialAny(ctx, b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := make(map[int]bus.Subscriber)
+	for _, p := range partitions {
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   b.cfg.Brokers,
+			Topic:     topic,
+			Partition: p.ID,
+			Dialer:    b.cfg.Dialer(),
+		})
+
+		offset, ok := offsets[p.ID]
+		if !ok {
+			offset = kafka.FirstOffset
+		}
+		if err := r.SetOffset(offset); err != nil {
+			r.Close()
+			for _, s := range subscribers {
+				s.Close()
+			}
+			return nil, err
+		}
+		subscribers[p.ID] = &subscriber{cfg: b.cfg, r: r, partition: p.ID}
+	}
+	return subscribers, nil
+}
+
+func (b *Bus) Close() error {
+	return nil
+}
+
+type publisher struct {
+	w *kafka.Writer
+}
+
+func (p *publisher) Publish(ctx context.Context, msgs ...bus.Message) error {
+	kafkaMessages := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		kafkaMessages = append(kafkaMessages, toKafka(m))
+	}
+
+	err := p.w.WriteMessages(ctx, kafkaMessages...)
+	if err != nil {
+		log.Printf("[trace %s] Failed to write message: %v\n", trace.FromContext(ctx), err)
+		return err
+	}
+	return nil
+}
+
+func (p *publisher) Close() error {
+	return p.w.Close()
+}
+
+type subscriber struct {
+	cfg       *Config
+	mu        sync.Mutex
+	r         *kafka.Reader
+	partition int
+}
+
+func (s *subscriber) reader() *kafka.Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r
+}
+
+func (s *subscriber) grouped() bool {
+	return s.reader().Config().GroupID != ""
+}
+
+func (s *subscriber) Fetch(ctx context.Context) (bus.Message, error) {
+	for {
+		r := s.reader()
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			// Ридер закрыли ради перемотки группы, читаем из нового
+			if ctx.Err() == nil && s.reader() != r {
+				continue
+			}
+			return bus.Message{}, err
+		}
+		return fromKafka(m), nil
+	}
+}
+
+// Без consumer group коммитить в Kafka нечего: оффсеты хранит сам сервис
+func (s *subscriber) Commit(ctx context.Context, msgs ...bus.Message) error {
+	if !s.grouped() {
+		return nil
+	}
+
+	kafkaMessages := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		kafkaMessages = append(kafkaMessages, kafka.Message{
+			Topic:     m.Topic,
+			Partition: m.Partition,
+			Offset:    m.Offset,
+		})
+	}
+	return s.reader().CommitMessages(ctx, kafkaMessages...)
+}
+
+func (s *subscriber) Seek(ctx context.Context, partition int, offset int64) error {
+	if s.grouped() {
+		return s.seekGroup(ctx, map[int]int64{partition: offset})
+	}
+	if partition != s.partition {
+		return fmt.Errorf("subscriber reads partition %d, not %d", s.partition, partition)
+	}
+	return s.reader().SetOffset(offset)
+}
+
+func (s *subscriber) SeekTime(ctx context.Context, t time.Time) error {
+	if !s.grouped() {
+		return s.reader().SetOffsetAt(ctx, t)
+	}
+
+	offsets, err := timeOffsets(ctx, s.cfg, s.reader().Config().Topic, t)
+	if err != nil {
+		return err
+	}
+	return s.seekGroup(ctx, offsets)
+}
+
+// Оффсеты группы меняются только когда в ней нет участников, поэтому
+// ридер выходит из группы, оффсеты коммитятся напрямую и ридер
+// создается заново. Если в группе есть другие реплики, брокер
+// отклонит коммит и чтение продолжится с прежних оффсетов
+func (s *subscriber) seekGroup(ctx context.Context, offsets map[int]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	readerConfig := s.r.Config()
+	if err := s.r.Close(); err != nil {
+		log.Println("Error closing reader before seek:", err)
+	}
+
+	err := commitGroupOffsets(ctx, s.cfg, readerConfig.GroupID, readerConfig.Topic, offsets)
+	s.r = kafka.NewReader(readerConfig)
+	if err != nil {
+		return fmt.Errorf("committing offsets for group %s: %w", readerConfig.GroupID, err)
+	}
+	log.Printf("Group %s moved to offsets %v on topic %s\n", readerConfig.GroupID, offsets, readerConfig.Topic)
+	return nil
+}
+
+func (s *subscriber) Lag(ctx context.Context) (int64, error) {
+	r := s.reader()
+	if !s.grouped() {
+		return r.ReadLag(ctx)
+	}
+	readerConfig := r.Config()
+	return groupLag(ctx, s.cfg, readerConfig.GroupID, readerConfig.Topic)
+}
+
+func (s *subscriber) Close() error {
+	return s.reader().Close()
+}
+
+func toKafka(m bus.Message) kafka.Message {
+	headers := make([]kafka.Header, 0, len(m.Headers))
+	for key, value := range m.Headers {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	return kafka.Message{
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}
+}
+
+func fromKafka(m kafka.Message) bus.Message {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	return bus.Message{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Time:      m.Time,
+	}
+}
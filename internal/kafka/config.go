@@ -0,0 +1,180 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"orders/internal/config"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	defaultBroker   = "kafka:9092"
+	defaultTopic    = "orders"
+	defaultDLQTopic = "orders.dlq"
+	defaultGroupID  = "orders-group"
+	defaultClientID = "orders-service"
+
+	defaultTopicsFile = "topics.yaml"
+)
+
+type Config struct {
+	Brokers     []string
+	Topic       string
+	EventTopics []string
+	GroupID     string
+	ClientID    string
+	TLS         *tls.Config
+	SASL        sasl.Mechanism
+	// Описания топиков из KAFKA_TOPICS_FILE
+	TopicSpecs []TopicSpec
+	// Не запускаться, если существующие топики не совпадают с описаниями
+	StrictTopics bool
+	// Топик для сообщений, которые не удалось обработать. Пустой – такие
+	// сообщения только логируются
+	DeadLetterTopic string
+}
+
+// Собирает настройки подключения к Kafka из переменных окружения.
+// Без переменных получается прежнее подключение к kafka:9092 без TLS и SASL
+func LoadConfig() (*Config, error) {
+	cfg := &Config{
+		Brokers:         config.GetList("KAFKA_BROKERS", []string{defaultBroker}),
+		Topic:           config.GetString("KAFKA_TOPIC", defaultTopic),
+		EventTopics:     config.GetList("KAFKA_EVENT_TOPICS", nil),
+		DeadLetterTopic: config.GetString("KAFKA_DLQ_TOPIC", defaultDLQTopic),
+		GroupID:         config.GetString("KAFKA_GROUP_ID", defaultGroupID),
+		ClientID:        config.GetString("KAFKA_CLIENT_ID", defaultClientID),
+		StrictTopics:    config.GetBool("KAFKA_TOPICS_STRICT", false),
+	}
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("KAFKA_BROKERS is empty")
+	}
+
+	specs, err := loadTopicSpecs(config.GetString("KAFKA_TOPICS_FILE", defaultTopicsFile))
+	if err != nil {
+		return nil, err
+	}
+	cfg.TopicSpecs = specs
+
+	tlsConfig, err := loadTLS()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLS = tlsConfig
+
+	mechanism, err := loadSASL()
+	if err != nil {
+		return nil, err
+	}
+	cfg.SASL = mechanism
+
+	return cfg, nil
+}
+
+func loadTLS() (*tls.Config, error) {
+	caFile := config.GetString("KAFKA_TLS_CA_FILE", "")
+	certFile := config.GetString("KAFKA_TLS_CERT_FILE", "")
+	keyFile := config.GetString("KAFKA_TLS_KEY_FILE", "")
+
+	enabled := config.GetBool("KAFKA_TLS_ENABLED", caFile != "" || certFile != "")
+	if !enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.GetBool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading KAFKA_TLS_CA_FILE: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadSASL() (sasl.Mechanism, error) {
+	mechanism := strings.ToUpper(config.GetString("KAFKA_SASL_MECHANISM", ""))
+	username := config.GetString("KAFKA_SASL_USERNAME", "")
+	password := config.GetString("KAFKA_SASL_PASSWORD", "")
+
+	switch mechanism {
+	case "":
+		return nil, nil
+	case "PLAIN":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported KAFKA_SASL_MECHANISM %q", mechanism)
+	}
+}
+
+// Все топики сервиса: основной, топики событий, DLQ и остальные
+// описанные в файле топиков
+func (c *Config) TopicNames() []string {
+	names := append([]string{c.Topic}, c.EventTopics...)
+	if c.DeadLetterTopic != "" && !slices.Contains(names, c.DeadLetterTopic) {
+		names = append(names, c.DeadLetterTopic)
+	}
+	for _, spec := range c.TopicSpecs {
+		if !slices.Contains(names, spec.Name) {
+			names = append(names, spec.Name)
+		}
+	}
+	return names
+}
+
+func (c *Config) Dialer() *kafka.Dialer {
+	return &kafka.Dialer{
+		ClientID:      c.ClientID,
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		TLS:           c.TLS,
+		SASLMechanism: c.SASL,
+	}
+}
+
+func (c *Config) Transport() *kafka.Transport {
+	return &kafka.Transport{
+		ClientID: c.ClientID,
+		TLS:      c.TLS,
+		SASL:     c.SASL,
+	}
+}
+
+func (c *Config) Client() *kafka.Client {
+	return &kafka.Client{
+		Addr:      kafka.TCP(c.Brokers...),
+		Transport: c.Transport(),
+	}
+}
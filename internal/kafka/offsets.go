@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Коммитит оффсеты от имени группы без участников, как это делает
+// простой (не групповой) консьюмер. Если в группе кто-то есть,
+// брокер отклонит запрос
+func commitGroupOffsets(ctx context.Context, cfg *Config, groupID, topic string, offsets map[int]int64) error {
+	partitions := make([]int, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+	slices.Sort(partitions)
+
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for _, partition := range partitions {
+		commits = append(commits, kafka.OffsetCommit{Partition: partition, Offset: offsets[partition]})
+	}
+
+	resp, err := cfg.Client().OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, partitions := range resp.Topics {
+		for _, p := range partitions {
+			if p.Error != nil {
+				return p.Error
+			}
+		}
+	}
+	return nil
+}
+
+// Номера партиций топика по возрастанию
+func topicPartitions(ctx context.Context, cfg *Config, topic string) ([]int, error) {
+	conn, err := dialAny(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(partitions))
+	for _, p := range partitions {
+		ids = append(ids, p.ID)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Запрашивает у брокера оффсеты партиций, по одному запросу на
+// партицию, собранному функцией request
+func listOffsets(ctx context.Context, cfg *Config, topic string, partitions []int, request func(partition int) kafka.OffsetRequest) (map[int]kafka.PartitionOffsets, error) {
+	requests := make([]kafka.OffsetRequest, 0, len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, request(p))
+	}
+
+	resp, err := cfg.Client().ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]kafka.PartitionOffsets)
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		result[p.Partition] = p
+	}
+	return result, nil
+}
+
+// Для каждой партиции находит первый оффсет с временем не раньше t.
+// Если таких сообщений нет, возвращается конец партиции. Брокер может
+// вернуть несколько оффсетов, из них берется наименьший
+func timeOffsets(ctx context.Context, cfg *Config, topic string, t time.Time) (map[int]int64, error) {
+	partitions, err := topicPartitions(ctx, cfg, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	byTime, err := listOffsets(ctx, cfg, topic, partitions, func(p int) kafka.OffsetRequest {
+		return kafka.TimeOffsetOf(p, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	last, err := listOffsets(ctx, cfg, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range partitions {
+		offsets[p] = last[p].LastOffset
+		for offset := range byTime[p].Offsets {
+			offsets[p] = min(offsets[p], offset)
+		}
+	}
+	return offsets, nil
+}
+
+// Суммарное отставание группы по всем партициям топика: разница между
+// концом партиции и закоммиченным оффсетом. Если группа еще ничего не
+// коммитила, отсчет идет от начала партиции
+func groupLag(ctx context.Context, cfg *Config, groupID, topic string) (int64, error) {
+	partitions, err := topicPartitions(ctx, cfg, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	first, err := listOffsets(ctx, cfg, topic, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return 0, err
+	}
+	last, err := listOffsets(ctx, cfg, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := cfg.Client().OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: groupID,
+		Topics:  map[string][]int{topic: partitions},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+
+	committed := make(map[int]int64)
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return 0, fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+
+	var lag int64
+	for _, p := range partitions {
+		from, ok := committed[p]
+		if !ok || from < 0 {
+			from = first[p].FirstOffset
+		}
+		lag += max(0, last[p].LastOffset-from)
+	}
+	return lag, nil
+}
@@ -0,0 +1,218 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"orders/internal/messages"
+	"orders/internal/repository"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const DefaultReplayGroup = "orders-replay"
+
+// Границы повтора задаются оффсетами (ToOffset включительно) или временем.
+// Отрицательные оффсеты и нулевое время означают начало и конец партиции
+// на момент запуска. Отрицательный Partition – все партиции топика
+type ReplayOptions struct {
+	Partition  int
+	FromOffset int64
+	ToOffset   int64
+	FromTime   time.Time
+	ToTime     time.Time
+	GroupID    string
+	DryRun     bool
+}
+
+type ReplaySummary struct {
+	Messages int
+	Inserted int
+	Skipped  int
+	Failed   int
+}
+
+func (s ReplaySummary) String() string {
+	return fmt.Sprintf("messages: %d, inserted: %d, skipped: %d, failed: %d",
+		s.Messages, s.Inserted, s.Skipped, s.Failed)
+}
+
+// Перечитывает топик в заданном диапазоне и прогоняет сообщения через
+// тот же декодер, валидацию и сохранение, что и основной консьюмер.
+// Оффсеты основной группы не трогаются: прогресс повтора коммитится
+// в отдельную группу opts.GroupID
+func Replay(ctx context.Context, cfg *Config, repo *repository.Repository, opts ReplayOptions) (ReplaySummary, error) {
+	var summary ReplaySummary
+
+	if opts.GroupID == "" {
+		opts.GroupID = DefaultReplayGroup
+	}
+
+	conn, err := dialAny(ctx, cfg)
+	if err != nil {
+		return summary, err
+	}
+	partitions, err := conn.ReadPartitions(cfg.Topic)
+	conn.Close()
+	if err != nil {
+		return summary, err
+	}
+
+	for _, p := range partitions {
+		if opts.Partition >= 0 && p.ID != opts.Partition {
+			continue
+		}
+
+		start, end, err := replayRange(ctx, cfg, p.ID, opts)
+		if err != nil {
+			return summary, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+		if start >= end {
+			log.Printf("Partition %d: nothing to replay\n", p.ID)
+			continue
+		}
+
+		log.Printf("Replaying partition %d, offsets [%d, %d)\n", p.ID, start, end)
+		last, err := replayPartition(ctx, cfg, repo, p.ID, start, end, opts.DryRun, &summary)
+		if err != nil {
+			return summary, fmt.Errorf("partition %d: %w", p.ID, err)
+		}
+
+		if !opts.DryRun && last >= start {
+			if err := commitReplayOffset(ctx, cfg, opts.GroupID, p.ID, last+1); err != nil {
+				log.Printf("Error committing replay offset for group %s: %v\n", opts.GroupID, err)
+			}
+		}
+	}
+	return summary, nil
+}
+
+func replayRange(ctx context.Context, cfg *Config, partition int, opts ReplayOptions) (int64, int64, error) {
+	var conn *kafka.Conn
+	var err error
+	for _, broker := range cfg.Brokers {
+		conn, err = cfg.Dialer().DialLeader(ctx, "tcp", broker, cfg.Topic, partition)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start, end := first, last
+	if !opts.FromTime.IsZero() {
+		if start, err = conn.ReadOffset(opts.FromTime); err != nil {
+			return 0, 0, err
+		}
+	}
+	if !opts.ToTime.IsZero() {
+		if end, err = conn.ReadOffset(opts.ToTime); err != nil {
+			return 0, 0, err
+		}
+	}
+	if opts.FromOffset >= 0 {
+		start = opts.FromOffset
+	}
+	if opts.ToOffset >= 0 {
+		end = opts.ToOffset + 1
+	}
+
+	return max(start, first), min(end, last), nil
+}
+
+// Возвращает оффсет последнего обработанного сообщения
+func replayPartition(ctx context.Context, cfg *Config, repo *repository.Repository, partition int, start, end int64, dryRun bool, summary *ReplaySummary) (int64, error) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.Brokers,
+		Topic:     cfg.Topic,
+		Partition: partition,
+		Dialer:    cfg.Dialer(),
+	})
+	defer r.Close()
+
+	if err := r.SetOffset(start); err != nil {
+		return start - 1, err
+	}
+
+	last := start - 1
+	for last+1 < end {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			return last, err
+		}
+		if m.Offset >= end {
+			break
+		}
+		last = m.Offset
+		summary.Messages++
+
+		bm := fromKafka(m)
+		orders, invalid, err := messages.DecodeAndValidate(bm)
+		if err != nil {
+			log.Printf("Offset %d: error decoding orders data: %v\n", m.Offset, err)
+			summary.Failed++
+			continue
+		}
+		for _, err := range invalid {
+			log.Printf("Offset %d: %v\n", m.Offset, err)
+			summary.Failed++
+		}
+
+		msgCtx := messages.MessageContext(ctx, bm)
+		if dryRun {
+			for _, order := range orders {
+				exists, err := repo.OrderExists(msgCtx, order.OrderUID)
+				switch {
+				case err != nil:
+					log.Printf("Offset %d: error checking order %s: %v\n", m.Offset, order.OrderUID, err)
+					summary.Failed++
+				case exists:
+					summary.Skipped++
+				default:
+					summary.Inserted++
+				}
+			}
+			continue
+		}
+
+		// Как у основного консьюмера: заказы сообщения сохраняются одной
+		// транзакцией, и оборванная вставка не оставляет заказ частично
+		saved, rejected, err := repo.SaveBatchToDB(orders, msgCtx)
+		if err != nil {
+			log.Printf("Offset %d: error saving %d orders: %v\n", m.Offset, len(orders), err)
+			summary.Failed += len(orders)
+			continue
+		}
+		summary.Inserted += len(saved)
+		for _, r := range rejected {
+			if repository.IsDuplicate(r.Err) {
+				summary.Skipped++
+				continue
+			}
+			log.Printf("Offset %d: error saving order %s: %v\n", m.Offset, r.Order.OrderUID, r.Err)
+			summary.Failed++
+		}
+
+		if err := repo.Cache.Set(msgCtx, saved...); err != nil {
+			log.Printf("Offset %d: error updating cache: %v\n", m.Offset, err)
+		}
+	}
+	return last, nil
+}
+
+func commitReplayOffset(ctx context.Context, cfg *Config, groupID string, partition int, offset int64) error {
+	if err := commitGroupOffsets(ctx, cfg, groupID, cfg.Topic, map[int]int64{partition: offset}); err != nil {
+		return err
+	}
+	log.Printf("Replay group %s committed partition %d at offset %d\n", groupID, partition, offset)
+	return nil
+}
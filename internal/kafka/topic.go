@@ -0,0 +1,282 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	maxDialRetries = 10
+	dialRetryDelay = 5 * time.Second
+
+	// Число партиций или фактор репликации по умолчанию брокера
+	brokerDefault = -1
+)
+
+// Описание топика в topics.yaml. Незаданные параметры топика берутся
+// из настроек брокера и при проверке не сравниваются
+type TopicSpec struct {
+	Name              string `yaml:"name"`
+	Partitions        int    `yaml:"partitions"`
+	ReplicationFactor int    `yaml:"replication_factor"`
+	RetentionMs       *int64 `yaml:"retention_ms"`
+	CleanupPolicy     string `yaml:"cleanup_policy"`
+	MaxMessageBytes   *int   `yaml:"max_message_bytes"`
+}
+
+type topicsFile struct {
+	Topics []TopicSpec `yaml:"topics"`
+}
+
+// Читает описания топиков из файла. Если файла нет, все топики
+// создаются с настройками брокера
+func loadTopicSpecs(path string) ([]TopicSpec, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Topics file %s not found, using defaults\n", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file topicsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for i, spec := range file.Topics {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("%s: topic #%d has no name", path, i+1)
+		}
+		if spec.Partitions < 0 || spec.ReplicationFactor < 0 {
+			return nil, fmt.Errorf("%s: topic %s has negative partitions or replication_factor", path, spec.Name)
+		}
+	}
+	return file.Topics, nil
+}
+
+func (c *Config) topicSpec(name string) TopicSpec {
+	for _, spec := range c.TopicSpecs {
+		if spec.Name == name {
+			return spec.withDefaults()
+		}
+	}
+	return TopicSpec{Name: name}.withDefaults()
+}
+
+// Незаданные число партиций и фактор репликации брокер при создании
+// топика берет из num.partitions и default.replication.factor
+func (t TopicSpec) withDefaults() TopicSpec {
+	if t.Partitions == 0 {
+		t.Partitions = brokerDefault
+	}
+	if t.ReplicationFactor == 0 {
+		t.ReplicationFactor = brokerDefault
+	}
+	return t
+}
+
+// Параметры топика в терминах Kafka, только заданные в описании
+func (t TopicSpec) configs() map[string]string {
+	configs := make(map[string]string)
+	if t.RetentionMs != nil {
+		configs["retention.ms"] = strconv.FormatInt(*t.RetentionMs, 10)
+	}
+	if t.CleanupPolicy != "" {
+		configs["cleanup.policy"] = t.CleanupPolicy
+	}
+	if t.MaxMessageBytes != nil {
+		configs["max.message.bytes"] = strconv.Itoa(*t.MaxMessageBytes)
+	}
+	return configs
+}
+
+// Пробует подключиться к брокерам по очереди
+func dialAny(ctx context.Context, cfg *Config) (*kafka.Conn, error) {
+	dialer := cfg.Dialer()
+
+	var lastErr error
+	for _, broker := range cfg.Brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Создает недостающие топики по описаниям и сверяет уже существующие.
+// Расхождения только логируются, а в строгом режиме возвращаются ошибкой
+func provisionTopics(ctx context.Context, cfg *Config, topics ...string) error {
+	client := cfg.Client()
+
+	var metadata *kafka.MetadataResponse
+	var err error
+	for i := 0; i < maxDialRetries; i++ {
+		metadata, err = client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+		if err == nil {
+			break
+		}
+		log.Printf("Error creating Kafka connection (attempt %d/%d): %v", i+1, maxDialRetries, err)
+
+		if i == maxDialRetries-1 {
+			return fmt.Errorf("failed to connect to Kafka after all attempts: %w", err)
+		}
+
+		time.Sleep(dialRetryDelay)
+	}
+
+	existing := make(map[string]kafka.Topic)
+	for _, t := range metadata.Topics {
+		if t.Error == nil {
+			existing[t.Name] = t
+		}
+	}
+
+	var missing []TopicSpec
+	var present []TopicSpec
+	for _, name := range topics {
+		spec := cfg.topicSpec(name)
+		if _, ok := existing[name]; ok {
+			present = append(present, spec)
+		} else {
+			missing = append(missing, spec)
+		}
+	}
+
+	if err := createTopics(ctx, client, missing); err != nil {
+		return err
+	}
+
+	mismatches, err := verifyTopics(ctx, client, present, existing)
+	if err != nil {
+		return fmt.Errorf("verifying topics: %w", err)
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	for _, m := range mismatches {
+		log.Println("Topic configuration mismatch:", m)
+	}
+	if cfg.StrictTopics {
+		return fmt.Errorf("%d topic configuration mismatches: %s", len(mismatches), strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+func createTopics(ctx context.Context, client *kafka.Client, specs []TopicSpec) error {
+	if len(specs) == 0 {
+		return nil
+	}
+
+	var names []string
+	var topicConfigs []kafka.TopicConfig
+	for _, spec := range specs {
+		var entries []kafka.ConfigEntry
+		for name, value := range spec.configs() {
+			entries = append(entries, kafka.ConfigEntry{ConfigName: name, ConfigValue: value})
+		}
+
+		names = append(names, spec.Name)
+		topicConfigs = append(topicConfigs, kafka.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+			ConfigEntries:     entries,
+		})
+	}
+
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: topicConfigs})
+	if err != nil {
+		return fmt.Errorf("creating topics: %w", err)
+	}
+	for topic, err := range resp.Errors {
+		// Топик мог создать другой экземпляр сервиса
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("creating topic %s: %w", topic, err)
+		}
+	}
+	log.Printf("Topics %s created successfuly", strings.Join(names, ","))
+	return nil
+}
+
+// Сравнивает число партиций, фактор репликации и заданные параметры
+// топиков с описаниями. Возвращает список расхождений
+func verifyTopics(ctx context.Context, client *kafka.Client, specs []TopicSpec, existing map[string]kafka.Topic) ([]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	var mismatches []string
+	var resources []kafka.DescribeConfigRequestResource
+	for _, spec := range specs {
+		topic := existing[spec.Name]
+		if spec.Partitions != brokerDefault && len(topic.Partitions) != spec.Partitions {
+			mismatches = append(mismatches, fmt.Sprintf("%s: partitions %d, expected %d",
+				spec.Name, len(topic.Partitions), spec.Partitions))
+		}
+		if spec.ReplicationFactor != brokerDefault && len(topic.Partitions) > 0 &&
+			len(topic.Partitions[0].Replicas) != spec.ReplicationFactor {
+			mismatches = append(mismatches, fmt.Sprintf("%s: replication factor %d, expected %d",
+				spec.Name, len(topic.Partitions[0].Replicas), spec.ReplicationFactor))
+		}
+
+		var names []string
+		for name := range spec.configs() {
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			resources = append(resources, kafka.DescribeConfigRequestResource{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: spec.Name,
+				ConfigNames:  names,
+			})
+		}
+	}
+
+	if len(resources) == 0 {
+		return mismatches, nil
+	}
+
+	resp, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("topic %s: %w", resource.ResourceName, resource.Error)
+		}
+
+		expected := specByName(specs, resource.ResourceName).configs()
+		for _, entry := range resource.ConfigEntries {
+			want, ok := expected[entry.ConfigName]
+			if ok && entry.ConfigValue != want {
+				mismatches = append(mismatches, fmt.Sprintf("%s: %s is %q, expected %q",
+					resource.ResourceName, entry.ConfigName, entry.ConfigValue, want))
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+func specByName(specs []TopicSpec, name string) TopicSpec {
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec
+		}
+	}
+	return TopicSpec{Name: name}
+}
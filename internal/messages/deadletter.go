@@ -0,0 +1,34 @@
+package messages
+
+import (
+	"strconv"
+	"time"
+
+	"orders/internal/bus"
+)
+
+// Заголовки сообщений в DLQ: откуда сообщение пришло и почему не
+// обработалось
+const (
+	HeaderDLQTopic     = "dlq-original-topic"
+	HeaderDLQPartition = "dlq-original-partition"
+	HeaderDLQOffset    = "dlq-original-offset"
+	HeaderDLQError     = "dlq-error"
+	HeaderDLQFailedAt  = "dlq-failed-at"
+)
+
+// Копия сообщения для DLQ: ключ, значение и заголовки сохраняются, чтобы
+// его можно было отправить обратно в исходный топик без изменений
+func DeadLetter(m bus.Message, err error) bus.Message {
+	headers := make(map[string]string, len(m.Headers)+5)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDLQTopic] = m.Topic
+	headers[HeaderDLQPartition] = strconv.Itoa(m.Partition)
+	headers[HeaderDLQOffset] = strconv.FormatInt(m.Offset, 10)
+	headers[HeaderDLQError] = err.Error()
+	headers[HeaderDLQFailedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	return bus.Message{Key: m.Key, Value: m.Value, Headers: headers}
+}
@@ -0,0 +1,71 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"orders/internal/bus"
+	"orders/internal/generator"
+)
+
+const (
+	HeaderEventType = "event-type"
+
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderCancelled     = "order.cancelled"
+	EventDeliveryUpdated    = "delivery.updated"
+)
+
+var knownEvents = map[string]bool{
+	EventOrderCreated:       true,
+	EventOrderStatusChanged: true,
+	EventOrderCancelled:     true,
+	EventDeliveryUpdated:    true,
+}
+
+type OrderStatusChanged struct {
+	OrderUID string `json:"order_uid"`
+	Status   string `json:"status"`
+}
+
+type OrderCancelled struct {
+	OrderUID string `json:"order_uid"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type DeliveryUpdated struct {
+	OrderUID string             `json:"order_uid"`
+	Delivery generator.Delivery `json:"delivery"`
+}
+
+// Тип события берется из заголовка event-type, затем из названия топика.
+// Сообщения без типа считаются созданием заказов, как в топике orders
+func EventType(m bus.Message) string {
+	if eventType := m.Header(HeaderEventType); eventType != "" {
+		return eventType
+	}
+	if knownEvents[m.Topic] {
+		return m.Topic
+	}
+	return EventOrderCreated
+}
+
+func NewEvent(ctx context.Context, eventType string, payload any) (bus.Message, error) {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return bus.Message{}, err
+	}
+
+	m := NewMessage(ctx, value, ContentTypeJSON, CurrentSchemaVersion)
+	m.Headers[HeaderEventType] = eventType
+	return m, nil
+}
+
+func DecodeEvent(m bus.Message, payload any) error {
+	if contentType := m.Header(HeaderContentType); contentType != "" && contentType != ContentTypeJSON {
+		return fmt.Errorf("unsupported content type %q for %s event", contentType, EventType(m))
+	}
+	return json.Unmarshal(m.Value, payload)
+}
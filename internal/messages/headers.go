@@ -0,0 +1,139 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"orders/internal/bus"
+	"orders/internal/config"
+	"orders/internal/generator"
+	"orders/internal/orderproto"
+	"orders/internal/trace"
+)
+
+const (
+	HeaderContentType   = "content-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderTraceID       = "trace-id"
+	HeaderProducedAt    = "produced-at"
+	HeaderProducer      = "producer"
+
+	ContentTypeJSON      = "application/json"
+	CurrentSchemaVersion = "1"
+)
+
+type decoder struct {
+	decode          func([]byte) ([]*generator.Order, error)
+	supportsVersion func(string) bool
+}
+
+var decoders = map[string]decoder{
+	ContentTypeJSON: {
+		decode:          decodeJSON,
+		supportsVersion: func(version string) bool { return version == CurrentSchemaVersion },
+	},
+	orderproto.ContentType: {
+		decode:          orderproto.UnmarshalOrders,
+		supportsVersion: protoVersionRegistered,
+	},
+}
+
+func decodeJSON(value []byte) ([]*generator.Order, error) {
+	var orders []*generator.Order
+	err := json.Unmarshal(value, &orders)
+	return orders, err
+}
+
+func encodeJSON(orders []*generator.Order) ([]byte, error) {
+	return json.Marshal(orders)
+}
+
+func producerName() string {
+	name := config.GetString("KAFKA_PRODUCER_NAME", "")
+	if name != "" {
+		return name
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "orders-service"
+	}
+	return "orders-service@" + hostname
+}
+
+func NewMessage(ctx context.Context, value []byte, contentType, schemaVersion string) bus.Message {
+	traceID := trace.FromContext(ctx)
+	if traceID == "" {
+		traceID = trace.NewID()
+	}
+
+	return bus.Message{
+		Value: value,
+		Headers: map[string]string{
+			HeaderContentType:   contentType,
+			HeaderSchemaVersion: schemaVersion,
+			HeaderTraceID:       traceID,
+			HeaderProducedAt:    time.Now().UTC().Format(time.RFC3339Nano),
+			HeaderProducer:      producerName(),
+		},
+	}
+}
+
+// Кодирует заказы в формат из KAFKA_ENCODING
+func EncodeOrders(ctx context.Context, orders []*generator.Order) (bus.Message, error) {
+	var m bus.Message
+
+	if Encoding() == EncodingProtobuf {
+		version := currentProtoVersion()
+		if version == "" {
+			return bus.Message{}, errors.New("protobuf schema is not registered")
+		}
+		m = NewMessage(ctx, orderproto.MarshalOrders(orders), orderproto.ContentType, version)
+	} else {
+		value, err := encodeJSON(orders)
+		if err != nil {
+			return bus.Message{}, err
+		}
+		m = NewMessage(ctx, value, ContentTypeJSON, CurrentSchemaVersion)
+	}
+
+	m.Headers[HeaderEventType] = EventOrderCreated
+	return m, nil
+}
+
+// Возвращает контекст с trace id из заголовков сообщения. Для сообщений
+// без заголовка создается новый trace id
+func MessageContext(ctx context.Context, m bus.Message) context.Context {
+	traceID := m.Header(HeaderTraceID)
+	if traceID == "" {
+		traceID = trace.NewID()
+	}
+	return trace.WithID(ctx, traceID)
+}
+
+// Выбирает декодер по заголовкам сообщения. Сообщения без заголовков
+// считаются JSON первой версии схемы
+func DecodeMessage(m bus.Message) ([]*generator.Order, error) {
+	contentType := m.Header(HeaderContentType)
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+
+	d, ok := decoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	version := m.Header(HeaderSchemaVersion)
+	if version == "" {
+		version = CurrentSchemaVersion
+	}
+	if !d.supportsVersion(version) {
+		return nil, fmt.Errorf("unsupported schema version %q for %s", version, contentType)
+	}
+	return d.decode(m.Value)
+}
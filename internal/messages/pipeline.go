@@ -0,0 +1,62 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+
+	"orders/internal/bus"
+	"orders/internal/generator"
+)
+
+func ValidateOrder(o *generator.Order) error {
+	var problems []error
+
+	if o.OrderUID == "" {
+		problems = append(problems, errors.New("order_uid is empty"))
+	}
+	if o.TrackNumber == "" {
+		problems = append(problems, errors.New("track_number is empty"))
+	}
+	if o.CustomerID == "" {
+		problems = append(problems, errors.New("customer_id is empty"))
+	}
+	if o.DateCreated.IsZero() {
+		problems = append(problems, errors.New("date_created is empty"))
+	}
+	if o.Payment.Transaction == "" {
+		problems = append(problems, errors.New("payment transaction is empty"))
+	}
+	if len(o.Items) == 0 {
+		problems = append(problems, errors.New("order has no items"))
+	}
+	for i, item := range o.Items {
+		if item.Price < 0 || item.TotalPrice < 0 {
+			problems = append(problems, fmt.Errorf("item %d has negative price", i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid order %q: %w", o.OrderUID, errors.Join(problems...))
+	}
+	return nil
+}
+
+// Декодирует сообщение и отделяет валидные заказы от невалидных.
+// Ошибка возвращается только если само сообщение не удалось декодировать
+func DecodeAndValidate(m bus.Message) ([]*generator.Order, []error, error) {
+	orders, err := DecodeMessage(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var valid []*generator.Order
+	var invalid []error
+	for _, order := range orders {
+		if err := ValidateOrder(order); err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		valid = append(valid, order)
+	}
+	return valid, invalid, nil
+}
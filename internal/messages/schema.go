@@ -0,0 +1,85 @@
+package messages
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"orders/internal/config"
+	"orders/internal/orderproto"
+	"orders/internal/registry"
+)
+
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+var (
+	schemaMu           sync.RWMutex
+	schemaRegistry     registry.Registry
+	protoSchemaVersion string
+	// Версии, уже найденные в реестре. Реестр читает файл с диска, а
+	// проверка нужна для каждого protobuf-сообщения
+	knownProtoVersions map[string]bool
+)
+
+func Encoding() string {
+	encoding := config.GetString("KAFKA_ENCODING", EncodingJSON)
+	if encoding != EncodingJSON && encoding != EncodingProtobuf {
+		log.Printf("Unknown KAFKA_ENCODING %q, falling back to %s\n", encoding, EncodingJSON)
+		return EncodingJSON
+	}
+	return encoding
+}
+
+// Регистрирует текущую proto-схему заказов. Версия из реестра
+// отправляется в заголовке schema-version protobuf-сообщений
+func InitSchemaRegistry(reg registry.Registry) error {
+	version, err := reg.Register(orderproto.Subject, "PROTOBUF", orderproto.Schema)
+	if err != nil {
+		return err
+	}
+
+	schemaMu.Lock()
+	schemaRegistry = reg
+	protoSchemaVersion = strconv.Itoa(version)
+	knownProtoVersions = map[string]bool{protoSchemaVersion: true}
+	schemaMu.Unlock()
+
+	log.Printf("Schema %s registered with version %d\n", orderproto.Subject, version)
+	return nil
+}
+
+// Неизвестные версии не запоминаются: схему могут зарегистрировать позже
+func protoVersionRegistered(version string) bool {
+	schemaMu.RLock()
+	reg, known := schemaRegistry, knownProtoVersions[version]
+	schemaMu.RUnlock()
+
+	if known {
+		return true
+	}
+	if reg == nil {
+		return false
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return false
+	}
+	if _, err := reg.Get(orderproto.Subject, v); err != nil {
+		return false
+	}
+
+	schemaMu.Lock()
+	knownProtoVersions[version] = true
+	schemaMu.Unlock()
+	return true
+}
+
+func currentProtoVersion() string {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	return protoSchemaVersion
+}
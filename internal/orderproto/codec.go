@@ -0,0 +1,329 @@
+package orderproto
+
+import (
+	_ "embed"
+	"fmt"
+	"time"
+
+	g "orders/internal/generator"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Кодирование написано вручную по схеме из orders.proto, поэтому номера
+// полей ниже должны совпадать с номерами в схеме
+
+//go:embed orders.proto
+var Schema string
+
+const (
+	ContentType = "application/x-protobuf"
+	Subject     = "orders-value"
+)
+
+func MarshalOrders(orders []*g.Order) []byte {
+	var b []byte
+	for _, order := range orders {
+		b = appendMessage(b, 1, marshalOrder(order))
+	}
+	return b
+}
+
+func UnmarshalOrders(b []byte) ([]*g.Order, error) {
+	var orders []*g.Order
+
+	err := walk(b, func(num protowire.Number, raw []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		order, err := unmarshalOrder(raw)
+		if err != nil {
+			return err
+		}
+		orders = append(orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func marshalOrder(o *g.Order) []byte {
+	var b []byte
+	b = appendString(b, 1, o.OrderUID)
+	b = appendString(b, 2, o.TrackNumber)
+	b = appendString(b, 3, o.Entry)
+	b = appendMessage(b, 4, marshalDelivery(&o.Delivery))
+	b = appendMessage(b, 5, marshalPayment(&o.Payment))
+	for i := range o.Items {
+		b = appendMessage(b, 6, marshalItem(&o.Items[i]))
+	}
+	b = appendString(b, 7, o.Locale)
+	b = appendString(b, 8, o.InternalSignature)
+	b = appendString(b, 9, o.CustomerID)
+	b = appendString(b, 10, o.DeliveryService)
+	b = appendString(b, 11, o.Shardkey)
+	b = appendInt(b, 12, int64(o.SmID))
+	if !o.DateCreated.IsZero() {
+		b = appendInt(b, 13, o.DateCreated.UnixNano())
+		// Смещение хранится отдельно, иначе после декодирования время
+		// оказалось бы в часовом поясе консьюмера
+		if _, offset := o.DateCreated.Zone(); offset != 0 {
+			b = appendInt(b, 16, int64(offset))
+		}
+	}
+	b = appendString(b, 14, o.OofShard)
+	b = appendString(b, 15, o.OrderStatus)
+	return b
+}
+
+func unmarshalOrder(b []byte) (*g.Order, error) {
+	var o g.Order
+	var offset int
+
+	err := walk(b, func(num protowire.Number, raw []byte, v uint64) error {
+		switch num {
+		case 1:
+			o.OrderUID = string(raw)
+		case 2:
+			o.TrackNumber = string(raw)
+		case 3:
+			o.Entry = string(raw)
+		case 4:
+			return unmarshalDelivery(raw, &o.Delivery)
+		case 5:
+			return unmarshalPayment(raw, &o.Payment)
+		case 6:
+			var item g.Item
+			if err := unmarshalItem(raw, &item); err != nil {
+				return err
+			}
+			item.OrderUID = o.OrderUID
+			o.Items = append(o.Items, item)
+		case 7:
+			o.Locale = string(raw)
+		case 8:
+			o.InternalSignature = string(raw)
+		case 9:
+			o.CustomerID = string(raw)
+		case 10:
+			o.DeliveryService = string(raw)
+		case 11:
+			o.Shardkey = string(raw)
+		case 12:
+			o.SmID = int(int64(v))
+		case 13:
+			o.DateCreated = time.Unix(0, int64(v))
+		case 16:
+			offset = int(int32(v))
+		case 14:
+			o.OofShard = string(raw)
+		case 15:
+			o.OrderStatus = string(raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.DateCreated.IsZero() {
+		o.DateCreated = o.DateCreated.In(zone(offset))
+	}
+	o.Delivery.OrderUID = o.OrderUID
+	o.Payment.OrderUID = o.OrderUID
+	return &o, nil
+}
+
+// Без смещения время возвращается в UTC, а не в местном поясе
+func zone(offset int) *time.Location {
+	if offset == 0 {
+		return time.UTC
+	}
+	return time.FixedZone("", offset)
+}
+
+func marshalDelivery(d *g.Delivery) []byte {
+	var b []byte
+	b = appendString(b, 1, d.Name)
+	b = appendString(b, 2, d.Phone)
+	b = appendString(b, 3, d.Zip)
+	b = appendString(b, 4, d.City)
+	b = appendString(b, 5, d.Address)
+	b = appendString(b, 6, d.Region)
+	b = appendString(b, 7, d.Email)
+	return b
+}
+
+func unmarshalDelivery(b []byte, d *g.Delivery) error {
+	return walk(b, func(num protowire.Number, raw []byte, _ uint64) error {
+		switch num {
+		case 1:
+			d.Name = string(raw)
+		case 2:
+			d.Phone = string(raw)
+		case 3:
+			d.Zip = string(raw)
+		case 4:
+			d.City = string(raw)
+		case 5:
+			d.Address = string(raw)
+		case 6:
+			d.Region = string(raw)
+		case 7:
+			d.Email = string(raw)
+		}
+		return nil
+	})
+}
+
+func marshalPayment(p *g.Payment) []byte {
+	var b []byte
+	b = appendString(b, 1, p.Transaction)
+	b = appendString(b, 2, p.RequestID)
+	b = appendString(b, 3, p.Currency)
+	b = appendString(b, 4, p.Provider)
+	b = appendInt(b, 5, int64(p.Amount))
+	b = appendInt(b, 6, int64(p.PaymentDT))
+	b = appendString(b, 7, p.Bank)
+	b = appendInt(b, 8, int64(p.DeliveryCost))
+	b = appendInt(b, 9, int64(p.GoodsTotal))
+	b = appendInt(b, 10, int64(p.CustomFee))
+	return b
+}
+
+func unmarshalPayment(b []byte, p *g.Payment) error {
+	return walk(b, func(num protowire.Number, raw []byte, v uint64) error {
+		switch num {
+		case 1:
+			p.Transaction = string(raw)
+		case 2:
+			p.RequestID = string(raw)
+		case 3:
+			p.Currency = string(raw)
+		case 4:
+			p.Provider = string(raw)
+		case 5:
+			p.Amount = int(int64(v))
+		case 6:
+			p.PaymentDT = int(int64(v))
+		case 7:
+			p.Bank = string(raw)
+		case 8:
+			p.DeliveryCost = int(int64(v))
+		case 9:
+			p.GoodsTotal = int(int64(v))
+		case 10:
+			p.CustomFee = int(int64(v))
+		}
+		return nil
+	})
+}
+
+func marshalItem(i *g.Item) []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(i.ChrtID))
+	b = appendString(b, 2, i.TrackNumber)
+	b = appendInt(b, 3, int64(i.Price))
+	b = appendString(b, 4, i.Rid)
+	b = appendString(b, 5, i.Name)
+	b = appendInt(b, 6, int64(i.Sale))
+	b = appendString(b, 7, i.Size)
+	b = appendInt(b, 8, int64(i.TotalPrice))
+	b = appendInt(b, 9, int64(i.NmID))
+	b = appendString(b, 10, i.Brand)
+	b = appendInt(b, 11, int64(i.Status))
+	return b
+}
+
+func unmarshalItem(b []byte, i *g.Item) error {
+	return walk(b, func(num protowire.Number, raw []byte, v uint64) error {
+		switch num {
+		case 1:
+			i.ChrtID = int(int64(v))
+		case 2:
+			i.TrackNumber = string(raw)
+		case 3:
+			i.Price = int(int64(v))
+		case 4:
+			i.Rid = string(raw)
+		case 5:
+			i.Name = string(raw)
+		case 6:
+			i.Sale = int(int64(v))
+		case 7:
+			i.Size = string(raw)
+		case 8:
+			i.TotalPrice = int(int64(v))
+		case 9:
+			i.NmID = int(int64(v))
+		case 10:
+			i.Brand = string(raw)
+		case 11:
+			i.Status = int(int64(v))
+		}
+		return nil
+	})
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// Обходит поля сообщения: для varint-полей передает значение, для
+// length-delimited – сырые байты. Неизвестные типы полей пропускаются
+func walk(b []byte, visit func(num protowire.Number, raw []byte, v uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("invalid varint in field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			if err := visit(num, nil, v); err != nil {
+				return err
+			}
+		case protowire.BytesType:
+			raw, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return fmt.Errorf("invalid bytes in field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			if err := visit(num, raw, 0); err != nil {
+				return err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("invalid field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package orderproto
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	g "orders/internal/generator"
+)
+
+func TestRoundTrip(t *testing.T) {
+	orders := g.MakeRandomOrder(3)
+	orders[0].DateCreated = time.Date(2025, 1, 2, 15, 4, 5, 6, time.FixedZone("", 3*60*60))
+	orders[1].DateCreated = time.Date(2025, 1, 2, 15, 4, 5, 0, time.FixedZone("", -5*60*60-30*60))
+	orders[2].DateCreated = time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	decoded, err := UnmarshalOrders(MarshalOrders(orders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(orders) {
+		t.Fatalf("got %d orders, want %d", len(decoded), len(orders))
+	}
+
+	for i, want := range orders {
+		got := decoded[i]
+		if !got.DateCreated.Equal(want.DateCreated) {
+			t.Errorf("order %d: date_created %v, want %v", i, got.DateCreated, want.DateCreated)
+		}
+		if got.DateCreated.Format(time.RFC3339Nano) != want.DateCreated.Format(time.RFC3339Nano) {
+			t.Errorf("order %d: offset lost: %s, want %s", i,
+				got.DateCreated.Format(time.RFC3339Nano), want.DateCreated.Format(time.RFC3339Nano))
+		}
+
+		// Декодер проставляет order_uid вложенным структурам
+		want.Delivery.OrderUID, want.Payment.OrderUID = want.OrderUID, want.OrderUID
+		got.DateCreated, want.DateCreated = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("order %d differs after round trip:\n got %+v\nwant %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSubjectNotFound = errors.New("subject not found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrIncompatible    = errors.New("schema is incompatible")
+)
+
+type Schema struct {
+	Subject   string    `json:"subject"`
+	Version   int       `json:"version"`
+	Type      string    `json:"schema_type"`
+	Schema    string    `json:"schema"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Минимальный набор операций Confluent Schema Registry, которым пользуется сервис
+type Registry interface {
+	Register(subject, schemaType, schema string) (int, error)
+	Get(subject string, version int) (Schema, error)
+	Latest(subject string) (Schema, error)
+	CheckCompatibility(subject, schema string) error
+}
+
+// Хранит версии каждого subject в отдельном JSON-файле внутри dir.
+// У каждой реплики свой файл, поэтому версия схемы не порядковый номер,
+// а выводится из ее текста: реплики присваивают одной схеме одну и ту же
+// версию и понимают сообщения друг друга. Версии, записанные раньше по
+// порядку, сохраняются как есть
+type FileRegistry struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileRegistry(dir string) (*FileRegistry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileRegistry{dir: dir}, nil
+}
+
+// Регистрирует схему и возвращает ее версию. Повторная регистрация
+// той же схемы возвращает уже существующую версию
+func (r *FileRegistry) Register(subject, schemaType, schema string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.load(subject)
+	if err != nil && !errors.Is(err, ErrSubjectNotFound) {
+		return 0, err
+	}
+
+	for _, s := range versions {
+		if s.Schema == schema {
+			return s.Version, nil
+		}
+	}
+
+	if len(versions) > 0 {
+		if err := checkProtoCompatibility(versions[len(versions)-1].Schema, schema); err != nil {
+			return 0, err
+		}
+	}
+
+	version := ContentVersion(schema)
+	for _, s := range versions {
+		if s.Version == version {
+			return 0, fmt.Errorf("%s: version %d is already taken by another schema", subject, version)
+		}
+	}
+
+	newSchema := Schema{
+		Subject:   subject,
+		Version:   version,
+		Type:      schemaType,
+		Schema:    schema,
+		CreatedAt: time.Now().UTC(),
+	}
+	versions = append(versions, newSchema)
+
+	if err := r.save(subject, versions); err != nil {
+		return 0, err
+	}
+	return newSchema.Version, nil
+}
+
+func (r *FileRegistry) Get(subject string, version int) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.load(subject)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	for _, s := range versions {
+		if s.Version == version {
+			return s, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: %s v%d", ErrVersionNotFound, subject, version)
+}
+
+func (r *FileRegistry) Latest(subject string) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.load(subject)
+	if err != nil {
+		return Schema{}, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (r *FileRegistry) CheckCompatibility(subject, schema string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions, err := r.load(subject)
+	if errors.Is(err, ErrSubjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return checkProtoCompatibility(versions[len(versions)-1].Schema, schema)
+}
+
+// Версия схемы по ее тексту: первые 31 бит sha256, чтобы версия
+// оставалась положительным int на любой платформе
+func ContentVersion(schema string) int {
+	sum := sha256.Sum256([]byte(schema))
+	return max(1, int(binary.BigEndian.Uint32(sum[:4])&0x7fffffff))
+}
+
+func (r *FileRegistry) path(subject string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(subject)
+	return filepath.Join(r.dir, name+".json")
+}
+
+func (r *FileRegistry) load(subject string) ([]Schema, error) {
+	data, err := os.ReadFile(r.path(subject))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Schema
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	return versions, nil
+}
+
+// Пишет во временный файл и переименовывает его, чтобы не оставить
+// битый файл при падении посреди записи
+func (r *FileRegistry) save(subject string, versions []Schema) error {
+	data, err := json.MarshalIndent(versions, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path(subject) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path(subject))
+}
+
+type protoField struct {
+	Type     string
+	Repeated bool
+}
+
+var (
+	messageRe  = regexp.MustCompile(`message\s+(\w+)\s*\{([^}]*)\}`)
+	fieldRe    = regexp.MustCompile(`(repeated\s+)?([\w.]+)\s+(\w+)\s*=\s*(\d+)\s*;`)
+	reservedRe = regexp.MustCompile(`reserved\s+([^;]+);`)
+)
+
+// Разбирает плоские (без вложенных сообщений) proto-схемы в виде
+// сообщение -> номер поля -> тип поля
+func parseProto(schema string) (map[string]map[string]protoField, map[string]map[string]bool) {
+	messages := make(map[string]map[string]protoField)
+	reserved := make(map[string]map[string]bool)
+
+	for _, m := range messageRe.FindAllStringSubmatch(schema, -1) {
+		name, body := m[1], m[2]
+		messages[name] = make(map[string]protoField)
+		reserved[name] = make(map[string]bool)
+
+		for _, f := range fieldRe.FindAllStringSubmatch(body, -1) {
+			if f[2] == "reserved" {
+				continue
+			}
+			messages[name][f[4]] = protoField{Type: f[2], Repeated: f[1] != ""}
+		}
+
+		for _, r := range reservedRe.FindAllStringSubmatch(body, -1) {
+			for _, number := range strings.Split(r[1], ",") {
+				reserved[name][strings.TrimSpace(number)] = true
+			}
+		}
+	}
+	return messages, reserved
+}
+
+// Новая схема совместима со старой, если все старые сообщения на месте,
+// номера полей не поменяли тип, а удаленные номера зарезервированы
+func checkProtoCompatibility(oldSchema, newSchema string) error {
+	oldMessages, _ := parseProto(oldSchema)
+	newMessages, newReserved := parseProto(newSchema)
+
+	var problems []string
+	for name, oldFields := range oldMessages {
+		newFields, ok := newMessages[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("message %s was removed", name))
+			continue
+		}
+
+		for number, oldField := range oldFields {
+			newField, ok := newFields[number]
+			if !ok {
+				if !newReserved[name][number] {
+					problems = append(problems, fmt.Sprintf("%s: field %s was removed without being reserved", name, number))
+				}
+				continue
+			}
+			if newField != oldField {
+				problems = append(problems, fmt.Sprintf("%s: field %s changed type", name, number))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompatible, strings.Join(problems, "; "))
+	}
+	return nil
+}
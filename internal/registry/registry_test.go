@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	schemaV1 = `message Order { string order_uid = 1; }`
+	schemaV2 = `message Order { string order_uid = 1; string status = 2; }`
+)
+
+// Реплики с разными файлами реестра должны присвоить схеме одну версию
+func TestVersionsMatchAcrossRegistries(t *testing.T) {
+	first, err := NewFileRegistry(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewFileRegistry(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Вторая реплика не видела первую версию схемы
+	if _, err := first.Register("orders-value", "PROTOBUF", schemaV1); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := first.Register("orders-value", "PROTOBUF", schemaV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := second.Register("orders-value", "PROTOBUF", schemaV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 != v2 {
+		t.Fatalf("same schema got versions %d and %d", v1, v2)
+	}
+
+	again, err := first.Register("orders-value", "PROTOBUF", schemaV2)
+	if err != nil || again != v1 {
+		t.Fatalf("re-registering returned %d, %v, want %d", again, err, v1)
+	}
+
+	got, err := second.Get("orders-value", v1)
+	if err != nil || got.Schema != schemaV2 {
+		t.Fatalf("Get returned %+v, %v", got, err)
+	}
+	if _, err := second.Get("orders-value", v1+1); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("unknown version: got %v, want ErrVersionNotFound", err)
+	}
+}
+
+func TestIncompatibleSchemaIsRejected(t *testing.T) {
+	reg, err := NewFileRegistry(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Register("orders-value", "PROTOBUF", schemaV2); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reg.Register("orders-value", "PROTOBUF", `message Order { int64 order_uid = 1; }`)
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("got %v, want ErrIncompatible", err)
+	}
+}
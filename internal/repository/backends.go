@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+	"sync"
+)
+
+// pid процессов Postgres, обслуживающих соединения пула. Уведомления об
+// изменениях от них пропускаются: свои записи сервис кладет в кэш сам
+type backendPIDs struct {
+	mu   sync.Mutex
+	pids map[int]struct{}
+}
+
+func (b *backendPIDs) add(pid int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pids[pid] = struct{}{}
+}
+
+func (b *backendPIDs) remove(pid int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pids, pid)
+}
+
+func (b *backendPIDs) contains(pid int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pids[pid]
+	return ok
+}
+
+// Открывает пул, соединения которого запоминают pid своего процесса
+// Postgres. Если драйвер не умеет создавать коннектор, pid не
+// запоминаются
+func openDB(driverName, dataSourceName string, own *backendPIDs) (*sql.DB, error) {
+	database, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	dc, ok := database.Driver().(driver.DriverContext)
+	if !ok {
+		return database, nil
+	}
+	connector, err := dc.OpenConnector(dataSourceName)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	database.Close()
+	return sql.OpenDB(pidConnector{Connector: connector, own: own}), nil
+}
+
+// Методы соединения lib/pq, которые использует database/sql
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type pidConnector struct {
+	driver.Connector
+	own *backendPIDs
+}
+
+func (c pidConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, ok := conn.(pqConn)
+	if !ok {
+		return conn, nil
+	}
+
+	pid, err := backendPID(ctx, pc)
+	if err != nil {
+		log.Println("Error reading database backend pid:", err)
+		conn.Close()
+		return nil, err
+	}
+	c.own.add(pid)
+	return pidConn{pqConn: pc, pid: pid, own: c.own}, nil
+}
+
+func backendPID(ctx context.Context, conn pqConn) (int, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT pg_backend_pid()", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return 0, err
+	}
+	pid, _ := dest[0].(int64)
+	return int(pid), nil
+}
+
+// После закрытия соединения pid может достаться чужому процессу
+type pidConn struct {
+	pqConn
+	pid int
+	own *backendPIDs
+}
+
+func (c pidConn) Close() error {
+	c.own.remove(c.pid)
+	return c.pqConn.Close()
+}
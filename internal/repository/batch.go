@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	db "orders/internal/database"
+	g "orders/internal/generator"
+)
+
+// Postgres не принимает больше 65535 параметров в одном запросе
+const maxQueryParams = 65535
+
+var (
+	orderColumns = []string{
+		"order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id",
+		"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "status",
+	}
+	deliveryColumns = []string{
+		"order_uid", "name", "phone", "zip", "city", "address", "region", "email",
+	}
+	paymentColumns = []string{
+		"order_uid", "transaction", "request_id", "currency", "provider", "amount",
+		"payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
+	}
+	itemColumns = []string{
+		"order_uid", "chrt_id", "track_number", "price", "rid", "name",
+		"sale", "size", "total_price", "nm_id", "brand", "status",
+	}
+)
+
+// Заказ из батча, который не удалось сохранить: дубликат или ошибка
+// в данных
+type Rejected struct {
+	Order *g.Order
+	Err   error
+}
+
+// Сохраняет заказы одной транзакцией. Если многострочная вставка
+// упала из-за данных, заказы вставляются по одному в той же транзакции:
+// дубликаты и заказы с ошибками возвращаются в rejected, остальные
+// сохраняются. Если переданы оффсеты консьюмера, они записываются в той
+// же транзакции, что и сами заказы. Ошибка возвращается, только если
+// транзакция не прошла целиком, например при недоступности бд
+func (r *Repository) SaveBatchToDB(orders []*g.Order, ctx context.Context, offsets ...db.SaveConsumerOffsetParams) ([]*g.Order, []Rejected, error) {
+	if len(orders) == 0 && len(offsets) == 0 {
+		return nil, nil, nil
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting batch transaction:", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	saved, rejected, err := insertOrSplit(ctx, tx, orders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queries := db.New(r.DB).WithTx(tx)
+	for _, offset := range offsets {
+		if err := queries.SaveConsumerOffset(ctx, offset); err != nil {
+			log.Println("Error saving consumer offset:", err)
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing batch transaction:", err)
+		return nil, nil, err
+	}
+
+	for _, order := range saved {
+		r.missing.remove(order.OrderUID)
+	}
+	return saved, rejected, nil
+}
+
+// Вставляет батч целиком, а если не вышло из-за данных – по одному
+// заказу. Неудачная вставка откатывается до точки сохранения, поэтому
+// транзакция остается рабочей
+func insertOrSplit(ctx context.Context, tx *sql.Tx, orders []*g.Order) ([]*g.Order, []Rejected, error) {
+	err := inSavepoint(ctx, tx, func() error { return insertBatch(ctx, tx, orders) })
+	switch {
+	case err == nil:
+		return orders, nil, nil
+	case IsStorageFailure(err):
+		return nil, nil, err
+	case len(orders) == 1:
+		return nil, []Rejected{{Order: orders[0], Err: err}}, nil
+	}
+
+	log.Printf("Batch insert failed, inserting %d orders one by one: %v\n", len(orders), err)
+	var saved []*g.Order
+	var rejected []Rejected
+	for _, order := range orders {
+		err := inSavepoint(ctx, tx, func() error { return insertBatch(ctx, tx, []*g.Order{order}) })
+		if IsStorageFailure(err) {
+			return nil, nil, err
+		}
+		if err != nil {
+			rejected = append(rejected, Rejected{Order: order, Err: err})
+			continue
+		}
+		saved = append(saved, order)
+	}
+	return saved, rejected, nil
+}
+
+func inSavepoint(ctx context.Context, tx *sql.Tx, f func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_insert"); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_insert"); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_insert")
+	return err
+}
+
+func insertBatch(ctx context.Context, tx *sql.Tx, orders []*g.Order) error {
+	var orderRows, deliveryRows, paymentRows, itemRows [][]any
+
+	for _, order := range orders {
+		orderRows = append(orderRows, []any{
+			order.OrderUID,
+			order.TrackNumber,
+			order.Entry,
+			order.Locale,
+			sql.NullString{
+				String: order.InternalSignature,
+				Valid:  order.InternalSignature != "",
+			},
+			order.CustomerID,
+			order.DeliveryService,
+			order.Shardkey,
+			int32(order.SmID),
+			order.DateCreated,
+			order.OofShard,
+			orderStatus(order),
+		})
+
+		deliveryRows = append(deliveryRows, []any{
+			order.OrderUID,
+			order.Delivery.Name,
+			order.Delivery.Phone,
+			order.Delivery.Zip,
+			order.Delivery.City,
+			order.Delivery.Address,
+			order.Delivery.Region,
+			order.Delivery.Email,
+		})
+
+		paymentRows = append(paymentRows, []any{
+			order.OrderUID,
+			order.Payment.Transaction,
+			sql.NullString{
+				String: order.Payment.RequestID,
+				Valid:  order.Payment.RequestID != "",
+			},
+			order.Payment.Currency,
+			order.Payment.Provider,
+			int32(order.Payment.Amount),
+			int64(order.Payment.PaymentDT),
+			order.Payment.Bank,
+			int32(order.Payment.DeliveryCost),
+			int32(order.Payment.GoodsTotal),
+			int32(order.Payment.CustomFee),
+		})
+
+		for _, item := range order.Items {
+			itemRows = append(itemRows, []any{
+				order.OrderUID,
+				int32(item.ChrtID),
+				item.TrackNumber,
+				int32(item.Price),
+				item.Rid,
+				item.Name,
+				int32(item.Sale),
+				item.Size,
+				int32(item.TotalPrice),
+				int32(item.NmID),
+				item.Brand,
+				int32(item.Status),
+			})
+		}
+	}
+
+	if err := insertRows(ctx, tx, "orders", orderColumns, orderRows); err != nil {
+		log.Println("Error inserting orders batch:", err)
+		return err
+	}
+	if err := insertRows(ctx, tx, "delivery", deliveryColumns, deliveryRows); err != nil {
+		log.Println("Error inserting delivery batch:", err)
+		return err
+	}
+	if err := insertRows(ctx, tx, "payments", paymentColumns, paymentRows); err != nil {
+		log.Println("Error inserting payments batch:", err)
+		return err
+	}
+	if err := insertRows(ctx, tx, "items", itemColumns, itemRows); err != nil {
+		log.Println("Error inserting items batch:", err)
+		return err
+	}
+	return nil
+}
+
+// Собирает многострочный INSERT и при необходимости режет строки на части,
+// чтобы не выйти за лимит параметров
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) error {
+	chunkSize := maxQueryParams / len(columns)
+
+	for start := 0; start < len(rows); start += chunkSize {
+		end := min(start+chunkSize, len(rows))
+		chunk := rows[start:end]
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+
+		args := make([]any, 0, len(chunk)*len(columns))
+		for i, row := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteString(")")
+			args = append(args, row...)
+		}
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Возвращает следующий к чтению оффсет для каждой партиции
+func (r *Repository) GetConsumerOffsets(ctx context.Context, groupID, topic string) (map[int]int64, error) {
+	queries := db.New(r.DB)
+
+	rows, err := queries.GetConsumerOffsets(ctx, db.GetConsumerOffsetsParams{
+		GroupID: groupID,
+		Topic:   topic,
+	})
+	if err != nil {
+		log.Println("Error getting consumer offsets:", err)
+		return nil, err
+	}
+
+	offsets := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		offsets[int(row.Partition)] = row.NextOffset
+	}
+	return offsets, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	db "orders/internal/database"
+	g "orders/internal/generator"
+)
+
+const (
+	StatusCreated   = "created"
+	StatusCancelled = "cancelled"
+)
+
+// Статус нового заказа из сообщения, по умолчанию created
+func orderStatus(order *g.Order) string {
+	if order.OrderStatus == "" {
+		return StatusCreated
+	}
+	return order.OrderStatus
+}
+
+func (r *Repository) UpdateOrderStatus(ctx context.Context, order_uid, status string) error {
+	queries := db.New(r.DB)
+
+	rows, err := queries.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
+		OrderUid: order_uid,
+		Status:   status,
+	})
+	if err != nil {
+		log.Println("Error updating order status:", err)
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return r.refreshCache(ctx, order_uid)
+}
+
+func (r *Repository) CancelOrder(ctx context.Context, order_uid string) error {
+	return r.UpdateOrderStatus(ctx, order_uid, StatusCancelled)
+}
+
+func (r *Repository) UpdateDelivery(ctx context.Context, order_uid string, delivery g.Delivery) error {
+	queries := db.New(r.DB)
+
+	rows, err := queries.UpdateDelivery(ctx, db.UpdateDeliveryParams{
+		OrderUid: order_uid,
+		Name:     delivery.Name,
+		Phone:    delivery.Phone,
+		Zip:      delivery.Zip,
+		City:     delivery.City,
+		Address:  delivery.Address,
+		Region:   delivery.Region,
+		Email:    delivery.Email,
+	})
+	if err != nil {
+		log.Println("Error updating delivery:", err)
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return r.refreshCache(ctx, order_uid)
+}
+
+// Перечитывает заказ из бд, чтобы в кэше не осталась старая версия
+func (r *Repository) refreshCache(ctx context.Context, order_uid string) error {
+	_, err := r.GetOrderById(order_uid, ctx, false)
+	return err
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"log"
+)
+
+// Ключ advisory lock'а, под которым реплики по очереди применяют миграции
+const migrationLock = 7_412_001
+
+// init.sql выполняется только при создании тома Postgres, поэтому
+// таблицы и колонки, появившиеся позже, создаются на старте сервиса.
+// Каждая миграция идемпотентна и выполняется при каждом запуске
+var migrations = []string{
+	createConsumerOffsets,
+	addOrderStatus,
+	createNotifyOrderChanged,
+	createOrderChangedTriggers,
+}
+
+const createConsumerOffsets = `
+CREATE TABLE IF NOT EXISTS consumer_offsets (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    next_offset BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, topic, partition)
+)`
+
+const addOrderStatus = `
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status VARCHAR(50) NOT NULL DEFAULT 'created'`
+
+// Уведомляет сервис об изменении заказа, чтобы он обновил кэш, см.
+// ChangeListener. Одинаковые уведомления в одной транзакции Postgres
+// отправляет один раз
+const createNotifyOrderChanged = `
+CREATE OR REPLACE FUNCTION notify_order_changed() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('order_changed', OLD.order_uid);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('order_changed', NEW.order_uid);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`
+
+const createOrderChangedTriggers = `
+CREATE OR REPLACE TRIGGER orders_changed
+AFTER INSERT OR UPDATE OR DELETE ON orders
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER delivery_changed
+AFTER INSERT OR UPDATE OR DELETE ON delivery
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER payments_changed
+AFTER INSERT OR UPDATE OR DELETE ON payments
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER items_changed
+AFTER INSERT OR UPDATE OR DELETE ON items
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed()`
+
+func (r *Repository) migrate(ctx context.Context) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Error starting migration transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		log.Println("Error locking migrations:", err)
+		return err
+	}
+	for _, migration := range migrations {
+		if _, err := tx.ExecContext(ctx, migration); err != nil {
+			log.Println("Error applying migration:", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing migrations:", err)
+		return err
+	}
+	return nil
+}
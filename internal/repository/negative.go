@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Помнит order_uid, которых нет в бд, чтобы повторные запросы к ним не
+// доходили до Postgres. Записи живут ttl, при переполнении первыми
+// удаляются самые старые
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	// Растет при каждом сохранении заказов. Промах, начавшийся до
+	// сохранения, не записывается: заказ мог появиться в бд
+	version uint64
+}
+
+type negativeEntry struct {
+	uid     string
+	expires time.Time
+}
+
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (n *negativeCache) enabled() bool {
+	return n.ttl > 0 && n.size > 0
+}
+
+func (n *negativeCache) contains(uid string) bool {
+	if !n.enabled() {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	el, ok := n.entries[uid]
+	if !ok {
+		return false
+	}
+	if time.Now().After(el.Value.(*negativeEntry).expires) {
+		n.order.Remove(el)
+		delete(n.entries, uid)
+		return false
+	}
+	return true
+}
+
+func (n *negativeCache) begin() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.version
+}
+
+func (n *negativeCache) add(uid string, version uint64) {
+	if !n.enabled() {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if version != n.version {
+		return
+	}
+
+	expires := time.Now().Add(n.ttl)
+	if el, ok := n.entries[uid]; ok {
+		el.Value.(*negativeEntry).expires = expires
+		n.order.MoveToBack(el)
+		return
+	}
+
+	n.entries[uid] = n.order.PushBack(&negativeEntry{uid: uid, expires: expires})
+	for n.order.Len() > n.size {
+		oldest := n.order.Front()
+		n.order.Remove(oldest)
+		delete(n.entries, oldest.Value.(*negativeEntry).uid)
+	}
+}
+
+// Вызывается после сохранения заказов в бд
+func (n *negativeCache) remove(uids ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.version++
+	for _, uid := range uids {
+		if el, ok := n.entries[uid]; ok {
+			n.order.Remove(el)
+			delete(n.entries, uid)
+		}
+	}
+}
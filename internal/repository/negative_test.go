@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	n := newNegativeCache(time.Minute, 2)
+
+	n.add("a", n.begin())
+	if !n.contains("a") {
+		t.Fatal("missing order is not remembered")
+	}
+
+	// Самая старая запись вытесняется при переполнении
+	n.add("b", n.begin())
+	n.add("c", n.begin())
+	if n.contains("a") || !n.contains("b") || !n.contains("c") {
+		t.Fatal("oldest entry was not evicted")
+	}
+
+	n.remove("b")
+	if n.contains("b") {
+		t.Fatal("saved order is still remembered as missing")
+	}
+}
+
+// Промах, начавшийся до сохранения заказа, не записывается: заказ уже
+// может быть в бд
+func TestNegativeCacheSkipsMissesStartedBeforeSave(t *testing.T) {
+	n := newNegativeCache(time.Minute, 10)
+
+	version := n.begin()
+	n.remove("a")
+	n.add("a", version)
+	if n.contains("a") {
+		t.Fatal("stale miss was remembered")
+	}
+}
+
+func TestNegativeCacheExpires(t *testing.T) {
+	n := newNegativeCache(10*time.Millisecond, 10)
+
+	n.add("a", n.begin())
+	time.Sleep(20 * time.Millisecond)
+	if n.contains("a") {
+		t.Fatal("entry did not expire")
+	}
+}
+
+func TestNegativeCacheDisabled(t *testing.T) {
+	n := newNegativeCache(0, 10)
+
+	n.add("a", n.begin())
+	if n.contains("a") {
+		t.Fatal("disabled cache remembered a miss")
+	}
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	c "orders/internal/cache"
+	"orders/internal/config"
+
+	"github.com/lib/pq"
+)
+
+const (
+	orderChangedChannel = "order_changed"
+	// Уведомления копятся это время, чтобы изменение заказа с десятком
+	// товаров обновляло кэш один раз
+	notifyDebounce = 100 * time.Millisecond
+	// pq рекомендует время от времени проверять соединение слушателя
+	listenerPing         = 90 * time.Second
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+	invalidationTimeout  = 10 * time.Second
+)
+
+type InvalidationMode string
+
+const (
+	// Закэшированный заказ перечитывается из бд
+	InvalidateRefresh InvalidationMode = "refresh"
+	// Заказ удаляется из кэша и загрузится при следующем запросе
+	InvalidateEvict InvalidationMode = "evict"
+	InvalidateNone  InvalidationMode = "none"
+)
+
+func parseInvalidationMode(value string) (InvalidationMode, error) {
+	switch mode := InvalidationMode(value); mode {
+	case InvalidateRefresh, InvalidateEvict, InvalidateNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown CACHE_INVALIDATION %q", value)
+	}
+}
+
+// Слушает уведомления триггеров из migrate.go и обновляет кэш, когда
+// заказ меняют в бд, в том числе в обход сервиса. Уведомления о
+// собственных записях пропускаются: сервис уже обновил кэш сам
+type ChangeListener struct {
+	repo     *Repository
+	mode     InvalidationMode
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// Режим задается CACHE_INVALIDATION: evict (по умолчанию), refresh
+// или none. При none слушатель не запускается и возвращается nil
+func (r *Repository) ListenForChanges(dsn string) (*ChangeListener, error) {
+	mode, err := parseInvalidationMode(config.GetString("CACHE_INVALIDATION", string(InvalidateEvict)))
+	if err != nil {
+		return nil, err
+	}
+	if mode == InvalidateNone {
+		log.Println("Cache invalidation from database is disabled")
+		return nil, nil
+	}
+
+	listener := pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Order change listener:", err)
+		}
+	})
+	if err := listener.Listen(orderChangedChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	log.Printf("Listening for order changes in database, cache mode: %s\n", mode)
+
+	l := &ChangeListener{repo: r, mode: mode, listener: listener, done: make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+func (l *ChangeListener) Close() error {
+	close(l.done)
+	return l.listener.Close()
+}
+
+func (l *ChangeListener) run() {
+	ping := time.NewTicker(listenerPing)
+	defer ping.Stop()
+
+	pending := make(map[string]struct{})
+	debounce := time.NewTimer(notifyDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return
+			}
+			// Уведомления за время обрыва потеряны. Кэш не очищается, чтобы
+			// после перезапуска бд на нее не пришла вся нагрузка разом
+			if n == nil {
+				log.Println("Order change listener reconnected, changes made while it was down are not applied to cache")
+				continue
+			}
+			if l.repo.own.contains(n.BePid) {
+				continue
+			}
+			if len(pending) == 0 {
+				debounce.Reset(notifyDebounce)
+			}
+			pending[n.Extra] = struct{}{}
+		case <-debounce.C:
+			l.apply(pending)
+			clear(pending)
+		case <-ping.C:
+			go func() {
+				if err := l.listener.Ping(); err != nil {
+					log.Println("Order change listener ping failed:", err)
+				}
+			}()
+		}
+	}
+}
+
+func (l *ChangeListener) apply(uids map[string]struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), invalidationTimeout)
+	defer cancel()
+
+	refreshed, evicted := 0, 0
+	for uid := range uids {
+		l.repo.missing.remove(uid)
+
+		if l.mode == InvalidateEvict {
+			if err := l.repo.Cache.Remove(ctx, uid); err == nil {
+				evicted++
+			}
+			continue
+		}
+
+		// Незакэшированные заказы не загружаются, иначе каждая вставка
+		// в бд вытесняла бы из кэша востребованные заказы
+		if _, _, err := l.repo.Cache.Inspect(ctx, uid); err != nil {
+			switch {
+			case c.IsUnavailable(err):
+				// Удаление запомнится и выполнится, когда кэш вернется
+				l.repo.Cache.Remove(ctx, uid)
+			case !errors.Is(err, c.ErrMiss):
+				log.Println("Error checking cached order:", err)
+			}
+			continue
+		}
+
+		_, err := l.repo.loadOrder(ctx, uid)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if err := l.repo.Cache.Remove(ctx, uid); err == nil {
+				evicted++
+			}
+		case err != nil:
+			log.Printf("Error refreshing cached order %s: %v\n", uid, err)
+		default:
+			refreshed++
+		}
+	}
+	log.Printf("Orders changed in database: %d, cache refreshed: %d, evicted: %d\n", len(uids), refreshed, evicted)
+}
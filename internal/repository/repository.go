@@ -3,20 +3,46 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"time"
 
 	c "orders/internal/cache"
+	"orders/internal/config"
 	db "orders/internal/database"
 	g "orders/internal/generator"
+
+	"github.com/lib/pq"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	uniqueViolation = "23505"
+	loadLockPoll    = 20 * time.Millisecond
+
+	defaultNegativeTTL  = 5 * time.Second
+	defaultNegativeSize = 10000
 )
 
 type Repository struct {
 	DB    *sql.DB
-	Cache *c.Cache
+	Cache c.Cache
+	// Загрузки заказов из бд при промахах кэша, по одной на order_uid
+	loads       singleflight.Group
+	loadLockTTL time.Duration
+	// order_uid, которых недавно не нашлось в бд
+	missing *negativeCache
+	// Процессы Postgres соединений пула, см. ChangeListener
+	own *backendPIDs
 }
 
-func NewRepository(driverName, dataSourceName string, cache *c.Cache) (*Repository, error) {
-	db, err := sql.Open(driverName, dataSourceName)
+func NewRepository(driverName, dataSourceName string, cache c.Cache) (*Repository, error) {
+	own := &backendPIDs{pids: make(map[int]struct{})}
+	db, err := openDB(driverName, dataSourceName, own)
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +58,24 @@ func NewRepository(driverName, dataSourceName string, cache *c.Cache) (*Reposito
 	}
 	log.Println("Database connection opened on db:5432")
 
-	return &Repository{DB: db, Cache: cache}, nil
+	r := &Repository{
+		DB:          db,
+		Cache:       cache,
+		loadLockTTL: config.GetDuration("CACHE_LOAD_LOCK_TTL", 0),
+		missing: newNegativeCache(
+			config.GetDuration("CACHE_NEGATIVE_TTL", defaultNegativeTTL),
+			config.GetInt("CACHE_NEGATIVE_SIZE", defaultNegativeSize),
+		),
+		own: own,
+	}
+
+	if err := r.migrate(context.Background()); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Println("NewRepository: Database connection can't be closed:", closeErr)
+		}
+		return nil, err
+	}
+	return r, nil
 }
 
 func (r *Repository) SaveToDB(orders []*g.Order, ctx context.Context) error {
@@ -54,12 +97,14 @@ func (r *Repository) SaveToDB(orders []*g.Order, ctx context.Context) error {
 			SmID:            int32(order.SmID),
 			DateCreated:     order.DateCreated,
 			OofShard:        order.OofShard,
+			Status:          orderStatus(order),
 		})
 		if err != nil {
 			log.Println("Error inserting order:", err)
 			return err
 
 		}
+		r.missing.remove(order.OrderUID)
 
 		err = queries.CreateDelivery(ctx, db.CreateDeliveryParams{
 			OrderUid: order.OrderUID,
@@ -120,111 +165,199 @@ func (r *Repository) SaveToDB(orders []*g.Order, ctx context.Context) error {
 			}
 		}
 
-		err = r.Cache.UpdateCache(ctx, order)
-		if err != nil {
-			return err
-		}
+		// Заказ уже в бд, а без кэша он просто загрузится при первом чтении
+		r.cacheOrder(ctx, order)
 	}
 	return nil
 }
 
 func (r *Repository) GetOrderById(order_uid string, ctx context.Context, useCache bool) (*g.Order, error) {
-	var orderData *g.Order
+	if !useCache {
+		return r.loadOrder(ctx, order_uid)
+	}
 
-	if useCache {
-		orderData, err := r.Cache.GetFromCache(ctx, order_uid)
-		if err != nil {
-			useCache = false
-		} else {
-			return orderData, nil
-		}
+	if r.missing.contains(order_uid) {
+		return nil, sql.ErrNoRows
 	}
 
-	if !useCache {
-		queries := db.New(r.DB)
+	orderData, err := r.Cache.Get(ctx, order_uid)
+	if err == nil {
+		return orderData, nil
+	}
+	return r.loadMissed(ctx, order_uid)
+}
 
-		order, err := queries.GetSpecificOrder(ctx, order_uid)
-		if err != nil {
-			log.Println("Error getting order:", err)
-			return nil, err
-		}
+// Готовый ответ GET /orders/{order_uid}. Попадание в кэш отдается без
+// декодирования заказа, промах загружается так же, как в GetOrderById
+func (r *Repository) GetOrderResponse(order_uid string, ctx context.Context) (*c.Rendered, error) {
+	if r.missing.contains(order_uid) {
+		return nil, sql.ErrNoRows
+	}
 
-		delivery, err := queries.GetSpecificDelivery(ctx, order_uid)
-		if err != nil {
-			log.Println("Error getting delivery:", err)
-			return nil, err
-		}
+	rendered, err := r.Cache.GetRendered(ctx, order_uid)
+	if err == nil {
+		return rendered, nil
+	}
 
-		payments, err := queries.GetSpecificPayment(ctx, order_uid)
-		if err != nil {
-			log.Println("Error getting payment:", err)
-			return nil, err
-		}
+	orderData, err := r.loadMissed(ctx, order_uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.Render(orderData)
+}
 
-		items, err := queries.GetSpecificItems(ctx, order_uid)
-		if err != nil {
-			log.Println("Error getting items:", err)
-			return nil, err
+// Одновременные промахи по одному заказу ждут одну загрузку из бд.
+// Загрузка не должна оборваться вместе с запросом, который ее начал
+func (r *Repository) loadMissed(ctx context.Context, order_uid string) (*g.Order, error) {
+	version := r.missing.begin()
+	loadCtx := context.WithoutCancel(ctx)
+	result, err, _ := r.loads.Do(order_uid, func() (any, error) {
+		return r.loadOrderLocked(loadCtx, order_uid)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.missing.add(order_uid, version)
 		}
+		return nil, err
+	}
+	return result.(*g.Order), nil
+}
 
-		var itemsList []g.Item
-		for _, item := range items {
-			itemsList = append(itemsList, g.Item{
-				ChrtID:      int(item.ChrtID),
-				TrackNumber: item.TrackNumber,
-				Price:       int(item.Price),
-				Rid:         item.Rid,
-				Name:        item.Name,
-				Sale:        int(item.Sale),
-				Size:        item.Size,
-				TotalPrice:  int(item.TotalPrice),
-				NmID:        int(item.NmID),
-				Brand:       item.Brand,
-				Status:      int(item.Status),
-			})
-		}
+// При включенной блокировке загрузки только одна реплика идет в бд,
+// остальные ждут, пока заказ появится в кэше. Если он так и не
+// появился за время блокировки, заказ читается из бд
+func (r *Repository) loadOrderLocked(ctx context.Context, order_uid string) (*g.Order, error) {
+	locker, ok := r.Cache.(c.Locker)
+	if !ok || r.loadLockTTL <= 0 {
+		return r.loadOrder(ctx, order_uid)
+	}
 
-		orderData = &g.Order{
-			OrderUID:    order.OrderUid,
-			TrackNumber: order.TrackNumber,
-			Entry:       order.Entry,
-			Delivery: g.Delivery{
-				Name:    delivery.Name,
-				Phone:   delivery.Phone,
-				Zip:     delivery.Zip,
-				City:    delivery.City,
-				Address: delivery.Address,
-				Region:  delivery.Region,
-				Email:   delivery.Email,
-			},
-			Payment: g.Payment{
-				Transaction:  payments.Transaction,
-				RequestID:    payments.RequestID.String,
-				Currency:     payments.Currency,
-				Provider:     payments.Provider,
-				Amount:       int(payments.Amount),
-				PaymentDT:    int(payments.PaymentDt),
-				Bank:         payments.Bank,
-				DeliveryCost: int(payments.DeliveryCost),
-				GoodsTotal:   int(payments.GoodsTotal),
-				CustomFee:    int(payments.CustomFee),
-			},
-			Items:             itemsList,
-			Locale:            order.Locale,
-			InternalSignature: order.InternalSignature.String,
-			CustomerID:        order.CustomerID,
-			DeliveryService:   order.DeliveryService,
-			Shardkey:          order.Shardkey,
-			SmID:              int(order.SmID),
-			DateCreated:       order.DateCreated,
-			OofShard:          order.OofShard,
+	unlock, acquired, err := locker.Lock(ctx, order_uid, r.loadLockTTL)
+	if err != nil {
+		if !errors.Is(err, c.ErrUnavailable) {
+			log.Println("Error acquiring cache lock:", err)
 		}
+		return r.loadOrder(ctx, order_uid)
+	}
+	if acquired {
+		defer unlock()
+		return r.loadOrder(ctx, order_uid)
+	}
 
-		err = r.Cache.UpdateCache(ctx, orderData)
-		if err != nil {
-			return nil, err
+	deadline := time.Now().Add(r.loadLockTTL)
+	for time.Now().Before(deadline) {
+		time.Sleep(loadLockPoll)
+		orderData, err := r.Cache.Get(ctx, order_uid)
+		if err == nil {
+			return orderData, nil
+		}
+		if errors.Is(err, c.ErrUnavailable) {
+			break
 		}
 	}
+	return r.loadOrder(ctx, order_uid)
+}
+
+// Читает заказ из бд и кладет его в кэш
+func (r *Repository) loadOrder(ctx context.Context, order_uid string) (*g.Order, error) {
+	orderData, err := r.getOrderFromDB(ctx, order_uid)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cacheOrder(ctx, orderData)
+	return orderData, nil
+}
+
+// Ошибка кэша не мешает отдать заказ из бд. Пока кэш недоступен,
+// ошибки не логируются: о размыкании цепи уже сообщил breaker
+func (r *Repository) cacheOrder(ctx context.Context, order *g.Order) {
+	if err := r.Cache.Set(ctx, order); err != nil && !errors.Is(err, c.ErrUnavailable) {
+		log.Println("Error caching order:", err)
+	}
+}
+
+func (r *Repository) getOrderFromDB(ctx context.Context, order_uid string) (*g.Order, error) {
+	queries := db.New(r.DB)
+
+	order, err := queries.GetSpecificOrder(ctx, order_uid)
+	if err != nil {
+		log.Println("Error getting order:", err)
+		return nil, err
+	}
+
+	delivery, err := queries.GetSpecificDelivery(ctx, order_uid)
+	if err != nil {
+		log.Println("Error getting delivery:", err)
+		return nil, err
+	}
+
+	payments, err := queries.GetSpecificPayment(ctx, order_uid)
+	if err != nil {
+		log.Println("Error getting payment:", err)
+		return nil, err
+	}
+
+	items, err := queries.GetSpecificItems(ctx, order_uid)
+	if err != nil {
+		log.Println("Error getting items:", err)
+		return nil, err
+	}
+
+	var itemsList []g.Item
+	for _, item := range items {
+		itemsList = append(itemsList, g.Item{
+			ChrtID:      int(item.ChrtID),
+			TrackNumber: item.TrackNumber,
+			Price:       int(item.Price),
+			Rid:         item.Rid,
+			Name:        item.Name,
+			Sale:        int(item.Sale),
+			Size:        item.Size,
+			TotalPrice:  int(item.TotalPrice),
+			NmID:        int(item.NmID),
+			Brand:       item.Brand,
+			Status:      int(item.Status),
+		})
+	}
+
+	orderData := &g.Order{
+		OrderUID:    order.OrderUid,
+		TrackNumber: order.TrackNumber,
+		Entry:       order.Entry,
+		Delivery: g.Delivery{
+			Name:    delivery.Name,
+			Phone:   delivery.Phone,
+			Zip:     delivery.Zip,
+			City:    delivery.City,
+			Address: delivery.Address,
+			Region:  delivery.Region,
+			Email:   delivery.Email,
+		},
+		Payment: g.Payment{
+			Transaction:  payments.Transaction,
+			RequestID:    payments.RequestID.String,
+			Currency:     payments.Currency,
+			Provider:     payments.Provider,
+			Amount:       int(payments.Amount),
+			PaymentDT:    int(payments.PaymentDt),
+			Bank:         payments.Bank,
+			DeliveryCost: int(payments.DeliveryCost),
+			GoodsTotal:   int(payments.GoodsTotal),
+			CustomFee:    int(payments.CustomFee),
+		},
+		Items:             itemsList,
+		Locale:            order.Locale,
+		InternalSignature: order.InternalSignature.String,
+		CustomerID:        order.CustomerID,
+		DeliveryService:   order.DeliveryService,
+		Shardkey:          order.Shardkey,
+		SmID:              int(order.SmID),
+		DateCreated:       order.DateCreated,
+		OofShard:          order.OofShard,
+		OrderStatus:       order.Status,
+	}
+
 	return orderData, nil
 }
 
@@ -233,25 +366,25 @@ func (r *Repository) GetAllOrders(ctx context.Context) ([]*g.Order, error) {
 
 	orders, err := queries.GetOrders(ctx)
 	if err != nil {
-		log.Fatalln("Error getting orders:", err)
+		log.Println("Error getting orders:", err)
 		return nil, err
 	}
 
 	deliveries, err := queries.GetDelivery(ctx)
 	if err != nil {
-		log.Fatalln("Error getting deliveries:", err)
+		log.Println("Error getting deliveries:", err)
 		return nil, err
 	}
 
 	payments, err := queries.GetPayment(ctx)
 	if err != nil {
-		log.Fatalln("Error getting payments:", err)
+		log.Println("Error getting payments:", err)
 		return nil, err
 	}
 
 	items, err := queries.GetItems(ctx)
 	if err != nil {
-		log.Fatalln("Error getting items:", err)
+		log.Println("Error getting items:", err)
 		return nil, err
 
 	}
@@ -323,30 +456,109 @@ func (r *Repository) GetAllOrders(ctx context.Context) ([]*g.Order, error) {
 			SmID:              int(order.SmID),
 			DateCreated:       order.DateCreated,
 			OofShard:          order.OofShard,
+			OrderStatus:       order.Status,
 		})
 	}
 
 	return ordersList, nil
 }
 
-func (r *Repository) GetLatestOrders(ctx context.Context, limit int32) ([]*g.Order, error) {
+// order_uid последних заказов по дате создания, от самых свежих
+func (r *Repository) GetLatestOrderUIDs(ctx context.Context, limit int32) ([]string, error) {
 	queries := db.New(r.DB)
 
-	latestOrders, err := queries.GetLatestOrders(ctx, limit)
+	uids, err := queries.GetLatestOrders(ctx, limit)
 	if err != nil {
 		log.Println("Error getting latest orders:", err)
 		return nil, err
 	}
+	return uids, nil
+}
+
+// order_uid последних заказов покупателей, от самых свежих
+func (r *Repository) GetCustomerOrderUIDs(ctx context.Context, customers []string, limit int32) ([]string, error) {
+	queries := db.New(r.DB)
+
+	uids, err := queries.GetCustomerOrders(ctx, db.GetCustomerOrdersParams{
+		CustomerIds: customers,
+		RowLimit:    limit,
+	})
+	if err != nil {
+		log.Println("Error getting customer orders:", err)
+		return nil, err
+	}
+	return uids, nil
+}
 
+// Читает заказы из бд в порядке uids, не трогая кэш. Заказы, которых
+// уже нет в бд, пропускаются
+func (r *Repository) GetOrdersFromDB(ctx context.Context, uids []string) ([]*g.Order, error) {
 	var ordersList []*g.Order
-	for _, orderUID := range latestOrders {
-		orderData, err := r.GetOrderById(orderUID, ctx, false)
+	for _, orderUID := range uids {
+		orderData, err := r.getOrderFromDB(ctx, orderUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
 		if err != nil {
 			log.Println("Error getting order data by id:", err)
-			continue
+			return nil, err
 		}
 		ordersList = append(ordersList, orderData)
 	}
-
 	return ordersList, nil
 }
+
+func (r *Repository) OrderExists(ctx context.Context, order_uid string) (bool, error) {
+	queries := db.New(r.DB)
+
+	_, err := queries.GetSpecificOrder(ctx, order_uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Сообщает, что заказ с таким order_uid уже сохранен
+func IsDuplicate(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// Отличает недоступность хранилищ от ошибок в самих данных: обрывы
+// соединения, таймауты и ошибки Postgres классов 08, 53, 57 и 58
+// могут пройти после восстановления, остальное повторять бесполезно
+func IsStorageFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53", "57", "58":
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// Проверяет, что бд отвечает. Кэш не проверяется: без него заказы
+// сохраняются и читаются из бд, а за его доступностью следит свой
+// breaker
+func (r *Repository) Ping(ctx context.Context) error {
+	if err := r.DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "orders/internal/cache"
+)
+
+// Драйвер бд, в которой нет ни одного заказа. Запросы считаются и
+// ждут, пока тест не закроет release
+type emptyDriver struct {
+	queries atomic.Int64
+	release chan struct{}
+}
+
+func (d *emptyDriver) Open(name string) (driver.Conn, error) { return emptyConn{d}, nil }
+
+func (d *emptyDriver) Connect(ctx context.Context) (driver.Conn, error) { return emptyConn{d}, nil }
+
+func (d *emptyDriver) Driver() driver.Driver { return d }
+
+type emptyConn struct{ d *emptyDriver }
+
+func (c emptyConn) Prepare(query string) (driver.Stmt, error) { return emptyStmt(c), nil }
+func (c emptyConn) Close() error                              { return nil }
+func (c emptyConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type emptyStmt struct{ d *emptyDriver }
+
+func (s emptyStmt) Close() error  { return nil }
+func (s emptyStmt) NumInput() int { return -1 }
+func (s emptyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s emptyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.queries.Add(1)
+	<-s.d.release
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newEmptyRepository(t *testing.T) (*Repository, *emptyDriver) {
+	t.Helper()
+
+	d := &emptyDriver{release: make(chan struct{})}
+	database := sql.OpenDB(d)
+	t.Cleanup(func() { database.Close() })
+
+	return &Repository{
+		DB:      database,
+		Cache:   c.NewMemoryCache(c.Options{Policy: c.PolicyLRU, Capacity: 10, Codec: c.Codec{Format: c.FormatJSON}}),
+		missing: newNegativeCache(time.Minute, 10),
+	}, d
+}
+
+// Одновременные промахи по одному заказу ждут один запрос в бд, а
+// результат "заказа нет" запоминается для следующих запросов
+func TestConcurrentMissesShareOneLoad(t *testing.T) {
+	r, d := newEmptyRepository(t)
+	ctx := context.Background()
+
+	const readers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.GetOrderById("missing", ctx, true)
+			errs <- err
+		}()
+	}
+
+	// Даем всем читателям дойти до singleflight
+	time.Sleep(50 * time.Millisecond)
+	close(d.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("got %v, want sql.ErrNoRows", err)
+		}
+	}
+	if got := d.queries.Load(); got != 1 {
+		t.Fatalf("%d queries for concurrent misses, want 1", got)
+	}
+
+	if _, err := r.GetOrderById("missing", ctx, true); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got %v, want sql.ErrNoRows", err)
+	}
+	if got := d.queries.Load(); got != 1 {
+		t.Fatalf("%d queries after the miss was remembered, want 1", got)
+	}
+}
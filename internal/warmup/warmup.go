@@ -0,0 +1,290 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"orders/internal/config"
+	repo "orders/internal/repository"
+)
+
+type Strategy string
+
+const (
+	// Последние заказы по дате создания
+	Latest Strategy = "latest"
+	// Самые востребованные заказы, сохраненные из кэша при остановке
+	Accessed Strategy = "accessed"
+	// Последние заказы заданных покупателей
+	Customers Strategy = "customers"
+	None      Strategy = "none"
+)
+
+type State string
+
+const (
+	Pending State = "pending"
+	Running State = "running"
+	Done    State = "done"
+	Failed  State = "failed"
+)
+
+// Заказы читаются из бд и попадают в кэш порциями
+const batchSize = 50
+
+type Settings struct {
+	Strategy Strategy
+	// Сколько заказов загрузить, по умолчанию – емкость кэша
+	Size      int
+	Customers []string
+	// Файл, в который при остановке сохраняются самые востребованные заказы
+	File string
+}
+
+// Читает CACHE_WARMUP, CACHE_WARMUP_SIZE, CACHE_WARMUP_CUSTOMERS
+// и CACHE_WARMUP_FILE
+func LoadSettings(capacity int) (Settings, error) {
+	s := Settings{
+		Strategy:  Strategy(config.GetString("CACHE_WARMUP", string(Latest))),
+		Size:      config.GetInt("CACHE_WARMUP_SIZE", capacity),
+		Customers: config.GetList("CACHE_WARMUP_CUSTOMERS", nil),
+		File:      config.GetString("CACHE_WARMUP_FILE", "cache-warmup.json"),
+	}
+
+	switch s.Strategy {
+	case Latest, Accessed, None:
+	case Customers:
+		if len(s.Customers) == 0 {
+			return s, errors.New("CACHE_WARMUP=customers requires CACHE_WARMUP_CUSTOMERS")
+		}
+	default:
+		return s, fmt.Errorf("unknown CACHE_WARMUP %q", s.Strategy)
+	}
+	if s.Size < 0 {
+		return s, fmt.Errorf("CACHE_WARMUP_SIZE must not be negative, got %d", s.Size)
+	}
+	return s, nil
+}
+
+type Progress struct {
+	Strategy   Strategy   `json:"strategy"`
+	State      State      `json:"state"`
+	Loaded     int        `json:"loaded"`
+	Total      int        `json:"total"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Заполняет кэш в фоне, пока сервис уже принимает запросы
+type Warmer struct {
+	repo     *repo.Repository
+	settings Settings
+
+	mu       sync.Mutex
+	progress Progress
+
+	// Отменяет прогрев, done закрывается после его завершения
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(r *repo.Repository, settings Settings) *Warmer {
+	w := &Warmer{
+		repo:     r,
+		settings: settings,
+		progress: Progress{Strategy: settings.Strategy, State: Pending},
+	}
+	if settings.Strategy == None {
+		w.progress.State = Done
+	}
+	return w
+}
+
+func (w *Warmer) Start(ctx context.Context) {
+	if w.settings.Strategy == None {
+		log.Println("Cache warmup is disabled")
+		return
+	}
+
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+}
+
+// Прерывает прогрев и ждет, пока он завершится, чтобы после остановки
+// он не обращался к закрытой бд
+func (w *Warmer) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+func (w *Warmer) Progress() Progress {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.progress
+}
+
+// Прогрев закончен, успешно или нет: без него сервис работает,
+// просто первые запросы пойдут в бд
+func (w *Warmer) Ready() bool {
+	state := w.Progress().State
+	return state == Done || state == Failed
+}
+
+func (w *Warmer) run(ctx context.Context) {
+	started := time.Now()
+	w.update(func(p *Progress) {
+		p.State = Running
+		p.StartedAt = &started
+	})
+
+	loaded, err := w.warm(ctx)
+
+	finished := time.Now()
+	w.update(func(p *Progress) {
+		p.FinishedAt = &finished
+		p.State = Done
+		if err != nil {
+			p.State = Failed
+			p.Error = err.Error()
+		}
+	})
+
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Cache warmup (%s) stopped after %d orders\n", w.settings.Strategy, loaded)
+		return
+	}
+	if err != nil {
+		log.Printf("Cache warmup (%s) failed after %d orders: %v\n", w.settings.Strategy, loaded, err)
+		return
+	}
+
+	stats, err := w.repo.Cache.Stats(ctx)
+	if err != nil {
+		log.Println("Error getting cache stats:", err)
+		return
+	}
+	log.Printf("Cache filled with %d/%d orders in %s, running on %s with %s policy\n",
+		stats.Size, stats.Capacity, finished.Sub(started).Round(time.Millisecond), stats.Backend, stats.Policy)
+}
+
+func (w *Warmer) warm(ctx context.Context) (int, error) {
+	uids, err := w.uids(ctx)
+	if err != nil {
+		return 0, err
+	}
+	w.update(func(p *Progress) { p.Total = len(uids) })
+
+	// Заказы идут от самых ценных, поэтому порции загружаются с конца,
+	// чтобы самые ценные оказались в кэше последними
+	loaded := 0
+	for end := len(uids); end > 0; end -= batchSize {
+		start := max(end-batchSize, 0)
+
+		orders, err := w.repo.GetOrdersFromDB(ctx, uids[start:end])
+		if err != nil {
+			return loaded, err
+		}
+		if _, err := w.repo.Cache.Warm(ctx, orders); err != nil {
+			return loaded, err
+		}
+
+		// Заказов может оказаться меньше, чем order_uid, если часть
+		// успели удалить из бд
+		loaded += len(orders)
+		w.update(func(p *Progress) { p.Loaded = loaded })
+	}
+	return loaded, nil
+}
+
+// order_uid для прогрева, от самых ценных
+func (w *Warmer) uids(ctx context.Context) ([]string, error) {
+	limit := int32(w.settings.Size)
+
+	switch w.settings.Strategy {
+	case Latest:
+		return w.repo.GetLatestOrderUIDs(ctx, limit)
+	case Customers:
+		return w.repo.GetCustomerOrderUIDs(ctx, w.settings.Customers, limit)
+	case Accessed:
+		uids, err := readHottest(w.settings.File)
+		if err != nil {
+			return nil, err
+		}
+		return uids[:min(len(uids), w.settings.Size)], nil
+	}
+	return nil, nil
+}
+
+func (w *Warmer) update(f func(p *Progress)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f(&w.progress)
+}
+
+// Сохраняет самые востребованные заказы из кэша для стратегии accessed
+// при следующем запуске
+func (w *Warmer) SaveHottest(ctx context.Context) error {
+	uids, err := w.repo.Cache.Hottest(ctx, w.settings.Size)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(uids)
+	if err != nil {
+		return err
+	}
+
+	// Файл подменяется целиком, чтобы не оставить его недописанным
+	tmp, err := os.CreateTemp(filepath.Dir(w.settings.File), ".cache-warmup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), w.settings.File); err != nil {
+		return err
+	}
+
+	log.Printf("Saved %d hottest cached orders to %s\n", len(uids), w.settings.File)
+	return nil
+}
+
+// Если файла еще нет, прогревать нечего
+func readHottest(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Cache warmup file %s not found, nothing to warm\n", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []string
+	if err := json.Unmarshal(data, &uids); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return uids, nil
+}
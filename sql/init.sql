@@ -9,7 +9,8 @@ CREATE TABLE IF NOT EXISTS orders (
     shardkey VARCHAR(10) NOT NULL,
     sm_id INTEGER NOT NULL,
     date_created TIMESTAMPTZ NOT NULL,
-    oof_shard VARCHAR(10) NOT NULL
+    oof_shard VARCHAR(10) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'created'
 );
 
 CREATE TABLE IF NOT EXISTS delivery (
@@ -58,3 +59,43 @@ CREATE TABLE IF NOT EXISTS items (
     brand VARCHAR(50) NOT NULL,
     status INT NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS consumer_offsets (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    next_offset BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, topic, partition)
+);
+
+-- Уведомляет сервис об изменении заказа, чтобы он обновил кэш. В том
+-- числе при правках напрямую в бд. Одинаковые уведомления в одной
+-- транзакции Postgres отправляет один раз
+CREATE OR REPLACE FUNCTION notify_order_changed() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('order_changed', OLD.order_uid);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('order_changed', NEW.order_uid);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER orders_changed
+AFTER INSERT OR UPDATE OR DELETE ON orders
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER delivery_changed
+AFTER INSERT OR UPDATE OR DELETE ON delivery
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER payments_changed
+AFTER INSERT OR UPDATE OR DELETE ON payments
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+CREATE OR REPLACE TRIGGER items_changed
+AFTER INSERT OR UPDATE OR DELETE ON items
+FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
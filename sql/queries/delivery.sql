@@ -17,3 +17,8 @@ SELECT * FROM delivery;
 
 -- name: GetSpecificDelivery :one
 SELECT * FROM delivery WHERE order_uid = $1;
+
+-- name: UpdateDelivery :execrows
+UPDATE delivery
+SET name = $2, phone = $3, zip = $4, city = $5, address = $6, region = $7, email = $8
+WHERE order_uid = $1;
@@ -0,0 +1,13 @@
+-- name: SaveConsumerOffset :exec
+INSERT INTO consumer_offsets (
+    group_id,
+    topic,
+    partition,
+    next_offset
+)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (group_id, topic, partition)
+DO UPDATE SET next_offset = EXCLUDED.next_offset, updated_at = NOW();
+
+-- name: GetConsumerOffsets :many
+SELECT * FROM consumer_offsets WHERE group_id = $1 AND topic = $2;
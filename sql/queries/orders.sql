@@ -10,9 +10,10 @@ INSERT INTO orders (
     shardkey,
     sm_id,
     date_created,
-    oof_shard
+    oof_shard,
+    status
 )
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 RETURNING *;
 
 -- name: GetOrders :many
@@ -23,3 +24,12 @@ SELECT * FROM orders WHERE order_uid = $1;
 
 -- name: GetLatestOrders :many
 SELECT order_uid FROM orders ORDER BY date_created DESC LIMIT $1;
+
+-- name: GetCustomerOrders :many
+SELECT order_uid FROM orders
+WHERE customer_id = ANY(sqlc.arg(customer_ids)::varchar[])
+ORDER BY date_created DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: UpdateOrderStatus :execrows
+UPDATE orders SET status = $2 WHERE order_uid = $1;
@@ -21,6 +21,11 @@ function loadOrder(orderUID) {
                 if (responce.status === 404) {
                     return null;
                 }
+                if (!responce.ok) {
+                    return responce.json().then((problem) => {
+                        throw new Error(`${problem.code}: ${problem.detail || problem.title} (request ${problem.request_id})`);
+                    });
+                }
                 return responce.json();
             })
             .then((order) => {